@@ -0,0 +1,142 @@
+//go:build cgo
+
+// Command libreclassify builds the classifier as a C shared library, so that
+// non-Go hosts can embed it in-process instead of shelling out:
+//
+//	go build -buildmode=c-shared -o libreclassify.so ./cmd/libreclassify
+//
+// The exported functions are:
+//
+//	uintptr_t reclassify_load(char *yaml, char **error);
+//	char *reclassify_classify(uintptr_t classifier, char *tokens, char **error);
+//	char *reclassify_classify_detailed(uintptr_t classifier, char *tokens, char **error);
+//	void reclassify_free(uintptr_t classifier);
+//	void reclassify_free_string(char *s);
+//
+// Tokens are passed to reclassify_classify one per line and the
+// classifications are returned one per line, exactly as in the classification
+// protocol. reclassify_classify_detailed returns instead a JSON array with an
+// object per token giving the fields of its classification: class, section,
+// pattern, capture_groups, end_tokens, operator (with prefix_prec, infix_prec
+// and postfix_prec) and serial. Strings returned by the library, including
+// error messages, must be released with reclassify_free_string.
+// functests/libreclassify/main.c is an example host.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// library is the state behind a classifier handle. Every call classifies
+// with its own clone of the engine, so a handle may be used from several
+// threads at once.
+type library struct {
+	cfg    *config.ClassifierConfig
+	engine *classifier.ClassifierEngine
+}
+
+// setError stores a copy of msg in *errOut, if errOut is not NULL.
+func setError(errOut **C.char, msg string) {
+	if errOut != nil {
+		*errOut = C.CString(msg)
+	}
+}
+
+//export reclassify_load
+func reclassify_load(yamlText *C.char, errOut **C.char) C.uintptr_t {
+	cfg, err := config.ParseClassifierConfig([]byte(C.GoString(yamlText)))
+	if err != nil {
+		setError(errOut, "failed to parse config: "+err.Error())
+		return 0
+	}
+	compiledConfig, err := cfg.CompileRegexes()
+	if err != nil {
+		setError(errOut, "failed to compile regexes: "+err.Error())
+		return 0
+	}
+	lib := &library{cfg: cfg, engine: classifier.NewClassifierEngine(compiledConfig)}
+	return C.uintptr_t(cgo.NewHandle(lib))
+}
+
+// prepare splits the tokens, one per line, and returns them with an engine
+// whose form mappings are built from them.
+func prepare(handle C.uintptr_t, tokens *C.char, errOut **C.char) ([]string, *classifier.ClassifierEngine, bool) {
+	if handle == 0 {
+		setError(errOut, "invalid classifier handle")
+		return nil, nil, false
+	}
+	lib := cgo.Handle(handle).Value().(*library)
+
+	var tokenList []string
+	for _, line := range strings.Split(C.GoString(tokens), "\n") {
+		if token := strings.TrimSpace(line); token != "" {
+			tokenList = append(tokenList, token)
+		}
+	}
+
+	engine := lib.engine.Clone()
+	if err := engine.BuildFormStartEndMappings(tokenList, lib.cfg); err != nil {
+		setError(errOut, "failed to build form mappings: "+err.Error())
+		return nil, nil, false
+	}
+	return tokenList, engine, true
+}
+
+//export reclassify_classify
+func reclassify_classify(handle C.uintptr_t, tokens *C.char, errOut **C.char) *C.char {
+	tokenList, engine, ok := prepare(handle, tokens, errOut)
+	if !ok {
+		return nil
+	}
+	var sb strings.Builder
+	for _, token := range tokenList {
+		sb.WriteString(engine.ClassifyToken(token))
+		sb.WriteByte('\n')
+	}
+	return C.CString(sb.String())
+}
+
+//export reclassify_classify_detailed
+func reclassify_classify_detailed(handle C.uintptr_t, tokens *C.char, errOut **C.char) *C.char {
+	tokenList, engine, ok := prepare(handle, tokens, errOut)
+	if !ok {
+		return nil
+	}
+	details := make([]classifier.Detail, len(tokenList))
+	for i, token := range tokenList {
+		details[i] = engine.ClassifyDetailed(token)
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		setError(errOut, "failed to encode classifications: "+err.Error())
+		return nil
+	}
+	return C.CString(string(b))
+}
+
+//export reclassify_free
+func reclassify_free(handle C.uintptr_t) {
+	if handle != 0 {
+		cgo.Handle(handle).Delete()
+	}
+}
+
+//export reclassify_free_string
+func reclassify_free_string(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// main is required by -buildmode=c-shared but is never called.
+func main() {}
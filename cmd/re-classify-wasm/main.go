@@ -0,0 +1,135 @@
+//go:build js && wasm
+
+// Command re-classify-wasm exposes the classifier to JavaScript when compiled
+// with GOOS=js GOARCH=wasm. It registers three global functions:
+//
+//	loadConfig(yamlString)    // returns null, or an error message
+//	classify(tokens)          // returns an array of classification strings
+//	classifyDetailed(tokens)  // returns an array of classification objects
+//
+// The objects of classifyDetailed have the fields class, section, pattern,
+// capture_groups, end_tokens, operator (with prefix_prec, infix_prec and
+// postfix_prec) and serial. Both classify functions throw if they are called
+// before a configuration has been loaded.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// Version is set at build time via -ldflags
+var Version = "unknown"
+
+var (
+	cfg    *config.ClassifierConfig
+	engine *classifier.ClassifierEngine
+)
+
+// loadConfig parses and compiles a YAML configuration. On failure the
+// previous configuration, if any, stays in effect.
+func loadConfig(this js.Value, args []js.Value) any {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return "loadConfig expects a single YAML string"
+	}
+
+	newCfg, err := config.ParseClassifierConfig([]byte(args[0].String()))
+	if err != nil {
+		return fmt.Sprintf("failed to parse config: %v", err)
+	}
+	compiledConfig, err := newCfg.CompileRegexes()
+	if err != nil {
+		return fmt.Sprintf("failed to compile regexes: %v", err)
+	}
+
+	cfg = newCfg
+	engine = classifier.NewClassifierEngine(compiledConfig)
+	return nil
+}
+
+// classify classifies an array of tokens, returning an array of the
+// 1-line classifications.
+func classify(this js.Value, args []js.Value) any {
+	tokens, err := prepare("classify", args)
+	if err != nil {
+		return jsError(err.Error())
+	}
+	results := make([]any, len(tokens))
+	for i, token := range tokens {
+		results[i] = engine.ClassifyToken(token)
+	}
+	return results
+}
+
+// classifyDetailed classifies an array of tokens, returning an array of
+// objects with the fields of each classification.
+func classifyDetailed(this js.Value, args []js.Value) any {
+	tokens, err := prepare("classifyDetailed", args)
+	if err != nil {
+		return jsError(err.Error())
+	}
+	details := make([]classifier.Detail, len(tokens))
+	for i, token := range tokens {
+		details[i] = engine.ClassifyDetailed(token)
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return jsError(err.Error())
+	}
+	return js.Global().Get("JSON").Call("parse", string(b))
+}
+
+// prepare takes the array of tokens passed to the function named, and builds
+// the form mappings from them.
+func prepare(name string, args []js.Value) ([]string, error) {
+	if engine == nil {
+		return nil, errors.New(name + " called before loadConfig")
+	}
+	if len(args) != 1 || args[0].Type() != js.TypeObject {
+		return nil, errors.New(name + " expects an array of tokens")
+	}
+
+	n := args[0].Length()
+	tokens := make([]string, n)
+	for i := 0; i < n; i++ {
+		tokens[i] = args[0].Index(i).String()
+	}
+
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// jsError returns a JavaScript Error, which throwing throws.
+func jsError(message string) js.Value {
+	return js.Global().Get("Error").New(message)
+}
+
+// throwing wraps a function so that an Error that it returns is thrown. A
+// Go function cannot throw itself: a panic would end the program.
+func throwing(f js.Func) js.Value {
+	wrap := js.Global().Get("Function").New("f", `return function(...args) {
+		const result = f(...args);
+		if (result instanceof Error) {
+			throw result;
+		}
+		return result;
+	};`)
+	return wrap.Invoke(f)
+}
+
+func main() {
+	js.Global().Set("reClassifyVersion", Version)
+	js.Global().Set("loadConfig", js.FuncOf(loadConfig))
+	js.Global().Set("classify", throwing(js.FuncOf(classify)))
+	js.Global().Set("classifyDetailed", throwing(js.FuncOf(classifyDetailed)))
+
+	// Keep the Go runtime alive so the exported functions remain callable.
+	select {}
+}
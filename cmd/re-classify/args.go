@@ -0,0 +1,22 @@
+package main
+
+import "flag"
+
+// parseInterspersed parses the flags of a subcommand, allowing them to come
+// after the positional arguments as in `gen config.yaml --per-pattern 5`. It
+// returns the positional arguments. The standard flag package stops at the
+// first positional argument, so parsing resumes after each one; "--" still
+// ends the flags.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		rest := fs.Args()
+		consumed := len(args) - len(rest)
+		if len(rest) == 0 || (consumed > 0 && args[consumed-1] == "--") {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
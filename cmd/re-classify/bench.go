@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+var benchCommand = &command{
+	name:    "bench",
+	args:    "[options] <config.yaml>",
+	summary: "Measure how fast tokens read from stdin are classified",
+	help: []string{
+		"Read tokens from stdin, one per line, and classify them repeatedly,",
+		"reporting the time taken to build the form mappings and the rate at",
+		"which tokens are classified.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		iterations := fs.Int("iterations", 10, "Number of times to classify the tokens")
+		tokensFile := fs.String("tokens", "", "Read the tokens from this file instead of stdin")
+		maxTokenBytes := fs.Int("max-token-bytes", classifier.DefaultMaxTokenBytes, "Maximum length of an input line in bytes")
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if args[0] == config.StdinSource && *tokensFile == "" {
+				usageError(fs, "--tokens must be specified when the config is read from stdin")
+			}
+			if *iterations < 1 {
+				usageError(fs, "--iterations must be at least 1")
+			}
+			cfg, compiledConfig, err := loadConfig(args[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			engine := classifier.NewClassifierEngine(compiledConfig)
+
+			start := time.Now()
+			tokens := readAndBuild(engine, cfg, openTokens(*tokensFile), *maxTokenBytes)
+			build := time.Since(start)
+
+			start = time.Now()
+			for range *iterations {
+				for _, token := range tokens {
+					engine.Classify(token)
+				}
+			}
+			elapsed := time.Since(start)
+
+			total := len(tokens) * *iterations
+			fmt.Printf("%d tokens, %d iterations\n", len(tokens), *iterations)
+			fmt.Printf("build:    %v\n", build.Round(time.Microsecond))
+			fmt.Printf("classify: %v (%.0f tokens/s, %v/token)\n", elapsed.Round(time.Microsecond),
+				float64(total)/max(elapsed.Seconds(), 1e-9), (elapsed / time.Duration(max(total, 1))).Round(time.Nanosecond))
+		}
+	},
+}
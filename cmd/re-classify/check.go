@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+var checkCommand = &command{
+	name:    "check",
+	args:    "[options] <config.yaml>",
+	summary: "Check that a config loads and its patterns compile",
+	help: []string{
+		"Load config.yaml and compile its patterns without reading any input.",
+		"Patterns that appear more than once, and operators with different",
+		"precedences that match the same token, are reported as warnings.",
+		"Exits with status 1 if the config is invalid.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		cacheDir := fs.String("cache-dir", "", "Cache parsed configurations in this directory, skipping the YAML parse but not the compilation")
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			cfg, err := loadClassifierConfig(args[0], *cacheDir)
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			if _, err := cfg.CompileRegexes(); err != nil {
+				fatal("error loading config", "error", fmt.Errorf("failed to compile regexes: %w", err))
+			}
+			warnOverlaps(cfg)
+			fmt.Println("Configuration syntax is valid")
+		}
+	},
+}
+
+// warnOverlaps warns about the patterns of the config that match the same
+// token, which it resolves silently.
+func warnOverlaps(cfg *config.ClassifierConfig) {
+	for _, o := range cfg.Overlaps() {
+		switch o.Kind {
+		case config.OverlapDuplicate:
+			slog.Warn("pattern appears more than once", "first", o.First, "second", o.Second)
+		case config.OverlapPrecedence:
+			slog.Warn("operators with different precedences match the same token", "token", o.Token, "first", o.First, "second", o.Second)
+		}
+	}
+}
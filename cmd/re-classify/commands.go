@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of re-classify.
+type command struct {
+	name    string
+	args    string   // Synopsis of the arguments e.g. "[options] <config.yaml>"
+	summary string   // One line for the list of commands
+	help    []string // Lines describing the command in its usage message
+
+	// setup defines the flags of the command on fs and returns the function
+	// that runs it with the positional arguments. Keeping the definition of
+	// the flags separate from running the command lets them be listed, e.g.
+	// for help, without running anything.
+	setup func(fs *flag.FlagSet) func(args []string)
+
+	// words returns the candidates for the positional arguments, for shell
+	// completion. If it is nil, config files are offered when args mentions
+	// one.
+	words func() []string
+
+	// usageToStderr sends the usage message to stderr, for commands whose
+	// stdout is a protocol stream.
+	usageToStderr bool
+}
+
+// commands lists the subcommands in the order they are listed by help. It is
+// filled in by init because help refers to it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		classifyCommand,
+		checkCommand,
+		testCommand,
+		benchCommand,
+		genCommand,
+		fuzzCommand,
+		optimizeCommand,
+		precedencesCommand,
+		convertCommand,
+		schemaCommand,
+		replCommand,
+		lspCommand,
+		serveCommand,
+		completionCommand,
+		versionCommand,
+		helpCommand,
+	}
+}
+
+// findCommand returns the command with the given name, or nil.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns the flag set of the command, including the logging
+// flags, and the function that runs it.
+func (c *command) newFlagSet() (*flag.FlagSet, func([]string), *logOptions) {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	if c.usageToStderr {
+		fs.SetOutput(os.Stderr)
+	} else {
+		fs.SetOutput(os.Stdout)
+	}
+	run := c.setup(fs)
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() { c.printUsage(fs.Output(), fs) }
+	return fs, run, logOpts
+}
+
+// execute parses the arguments of the command and runs it.
+func (c *command) execute(args []string) {
+	fs, run, logOpts := c.newFlagSet()
+	positional := parseInterspersed(fs, args)
+	logOpts.setup()
+	run(positional)
+}
+
+// printUsage writes the usage message of the command.
+func (c *command) printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: %s %s %s\n\n", os.Args[0], c.name, c.args)
+	for _, line := range c.help {
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w, "\nOptions:")
+	fs.PrintDefaults()
+}
+
+// printCommands writes the usage message of re-classify itself, listing the
+// commands.
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [options] [arguments]\n", os.Args[0])
+	fmt.Fprintf(w, "       %s [options] <config.yaml>   (the same as classify)\n\n", os.Args[0])
+	fmt.Fprintln(w, "re-classify is a token classification tool that uses regex patterns")
+	fmt.Fprintln(w, "to classify identifiers and operators in monogram syntax.")
+	fmt.Fprintln(w, "\nCommands:")
+	width := 0
+	for _, cmd := range commands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the options of a command.\n", os.Args[0])
+}
+
+// runLegacy runs classify with the arguments of re-classify itself, which is
+// how it was invoked before there were subcommands. The usage message lists
+// the commands as well as the options of classify.
+func runLegacy(args []string) {
+	fs, run, logOpts := classifyCommand.newFlagSet()
+	fs.Usage = func() {
+		printCommands(fs.Output())
+		fmt.Fprintln(fs.Output(), "\nOptions of classify:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	logOpts.setup()
+	run(fs.Args())
+}
+
+var versionCommand = &command{
+	name:    "version",
+	args:    "",
+	summary: "Show version information",
+	help:    []string{"Show the version of re-classify."},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		return func(args []string) {
+			if len(args) != 0 {
+				usageError(fs, "version takes no arguments")
+			}
+			fmt.Printf("re-classify version %s\n", Version)
+		}
+	},
+}
+
+var helpCommand = &command{
+	name:    "help",
+	args:    "[command]",
+	summary: "Show the commands, or the options of one command",
+	help:    []string{"List the commands, or show the usage message of the named command."},
+	words:   commandNames,
+	setup: func(fs *flag.FlagSet) func([]string) {
+		return func(args []string) {
+			switch len(args) {
+			case 0:
+				printCommands(os.Stdout)
+			case 1:
+				cmd := findCommand(args[0])
+				if cmd == nil {
+					usageError(fs, fmt.Sprintf("unknown command %q (expected one of %s)", args[0], strings.Join(commandNames(), ", ")))
+				}
+				cmdFS, _, _ := cmd.newFlagSet()
+				cmdFS.SetOutput(os.Stdout)
+				cmd.printUsage(os.Stdout, cmdFS)
+			default:
+				usageError(fs, "help takes at most one command")
+			}
+		}
+	},
+}
+
+// commandNames returns the names of the commands.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// completionShells lists the shells that completion scripts are written for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// configSuffixes are the file suffixes offered where a config file is
+// expected.
+var configSuffixes = []string{".yaml", ".yml", ".json"}
+
+var completionCommand = &command{
+	name:    "completion",
+	args:    "bash|zsh|fish",
+	summary: "Print a shell completion script",
+	help: []string{
+		"Print a script that completes the commands, options and config files of",
+		"re-classify in the given shell. For example:",
+		"",
+		"  source <(re-classify completion bash)",
+		"  re-classify completion zsh > \"${fpath[1]}/_re-classify\"",
+		"  re-classify completion fish > ~/.config/fish/completions/re-classify.fish",
+	},
+	words: func() []string { return completionShells },
+	setup: func(fs *flag.FlagSet) func([]string) {
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one shell must be specified")
+			}
+			specs := completionSpecs()
+			switch args[0] {
+			case "bash":
+				writeBashCompletion(os.Stdout, specs)
+			case "zsh":
+				writeZshCompletion(os.Stdout, specs)
+			case "fish":
+				writeFishCompletion(os.Stdout, specs)
+			default:
+				usageError(fs, fmt.Sprintf("unknown shell %q (expected one of %s)", args[0], strings.Join(completionShells, ", ")))
+			}
+		}
+	},
+}
+
+// completionSpec is what the completion scripts know about a command.
+type completionSpec struct {
+	name       string
+	summary    string
+	flags      []completionFlag
+	words      []string // Candidates for the positional arguments
+	configFile bool     // Whether the positional arguments include a config file
+}
+
+// completionFlag describes one flag of a command.
+type completionFlag struct {
+	name       string
+	usage      string
+	takesValue bool
+}
+
+// completionSpecs describes the commands, taking their flags from the flag
+// sets that they define so that the scripts cannot fall out of step.
+func completionSpecs() []completionSpec {
+	specs := make([]completionSpec, 0, len(commands))
+	for _, cmd := range commands {
+		spec := completionSpec{name: cmd.name, summary: cmd.summary}
+		if cmd.words != nil {
+			spec.words = cmd.words()
+		} else {
+			spec.configFile = strings.Contains(cmd.args, "<config")
+		}
+		fs, _, _ := cmd.newFlagSet()
+		fs.VisitAll(func(f *flag.Flag) {
+			b, ok := f.Value.(interface{ IsBoolFlag() bool })
+			spec.flags = append(spec.flags, completionFlag{
+				name:       f.Name,
+				usage:      f.Usage,
+				takesValue: !ok || !b.IsBoolFlag(),
+			})
+		})
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// flagNames returns the flags of the spec as --name, optionally only those
+// that take a value.
+func (s *completionSpec) flagNames(valuesOnly bool) string {
+	var names []string
+	for _, f := range s.flags {
+		if f.takesValue || !valuesOnly {
+			names = append(names, "--"+f.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// writeBashCompletion writes the bash completion script. Without a command,
+// the flags and config files of classify are offered, as re-classify accepts
+// them in place of a command.
+func writeBashCompletion(w io.Writer, specs []completionSpec) {
+	var names []string
+	for _, s := range specs {
+		names = append(names, s.name)
+	}
+	classify := specs[0]
+
+	fmt.Fprintln(w, "# bash completion for re-classify")
+	fmt.Fprintln(w, "_re_classify_config_files() {")
+	fmt.Fprintf(w, "    compgen -f -- \"$1\" | grep -E '(%s)$'\n", strings.ReplaceAll(strings.Join(configSuffixes, "|"), ".", `\.`))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_re_classify() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    local cmd="" flags values words config=1`)
+	fmt.Fprintln(w, `    [[ $COMP_CWORD -gt 1 ]] && cmd="${COMP_WORDS[1]}"`)
+	fmt.Fprintln(w, `    case "$cmd" in`)
+	for _, s := range specs {
+		fmt.Fprintf(w, "    %s)\n", s.name)
+		fmt.Fprintf(w, "        flags=%q\n", s.flagNames(false))
+		fmt.Fprintf(w, "        values=%q\n", s.flagNames(true))
+		fmt.Fprintf(w, "        words=%q\n", strings.Join(s.words, " "))
+		if !s.configFile {
+			fmt.Fprintln(w, "        config=0")
+		}
+		fmt.Fprintln(w, "        ;;")
+	}
+	fmt.Fprintln(w, "    *)")
+	fmt.Fprintf(w, "        flags=%q\n", classify.flagNames(false))
+	fmt.Fprintf(w, "        values=%q\n", classify.flagNames(true))
+	fmt.Fprintln(w, `        [[ $COMP_CWORD -eq 1 ]] && words=`+fmt.Sprintf("%q", strings.Join(names, " ")))
+	fmt.Fprintln(w, "        ;;")
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `    local opt="${prev#-}"`)
+	fmt.Fprintln(w, `    if [[ $prev == -* && " $values " == *" --${opt#-} "* ]]; then`)
+	fmt.Fprintln(w, `        COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, `    elif [[ $cur == -* ]]; then`)
+	fmt.Fprintln(w, `        COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	fmt.Fprintln(w, `    else`)
+	fmt.Fprintln(w, `        COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(w, `        [[ $config -eq 1 ]] && COMPREPLY+=($(_re_classify_config_files "$cur"))`)
+	fmt.Fprintln(w, `    fi`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -o plusdirs -F _re_classify re-classify")
+}
+
+// zshQuote escapes text for use in an _arguments or _describe spec inside
+// single quotes.
+func zshQuote(s string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// writeZshCompletion writes the zsh completion script.
+func writeZshCompletion(w io.Writer, specs []completionSpec) {
+	configGlob := "*.(" + strings.ReplaceAll(strings.Join(configSuffixes, "|"), ".", "") + ")"
+	arguments := func(s completionSpec, indent string) {
+		fmt.Fprintf(w, "%s_arguments -S \\\n", indent)
+		for _, f := range s.flags {
+			if f.takesValue {
+				fmt.Fprintf(w, "%s  '--%s=[%s]:%s:_files' \\\n", indent, f.name, zshQuote(f.usage), f.name)
+			} else {
+				fmt.Fprintf(w, "%s  '--%s[%s]' \\\n", indent, f.name, zshQuote(f.usage))
+			}
+		}
+		switch {
+		case len(s.words) > 0:
+			fmt.Fprintf(w, "%s  '*:argument:(%s)'\n", indent, strings.Join(s.words, " "))
+		case s.configFile:
+			fmt.Fprintf(w, "%s  '*:config file:_files -g \"%s\"'\n", indent, configGlob)
+		default:
+			fmt.Fprintf(w, "%s  '*: :'\n", indent)
+		}
+	}
+
+	fmt.Fprintln(w, "#compdef re-classify")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_re_classify() {")
+	fmt.Fprintln(w, "  local -a commands")
+	fmt.Fprintln(w, "  commands=(")
+	for _, s := range specs {
+		fmt.Fprintf(w, "    '%s:%s'\n", s.name, zshQuote(s.summary))
+	}
+	fmt.Fprintln(w, "  )")
+	fmt.Fprintln(w, "  if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then")
+	fmt.Fprintln(w, "    _describe -t commands 'command' commands")
+	fmt.Fprintf(w, "    _files -g '%s'\n", configGlob)
+	fmt.Fprintln(w, "    return")
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, "  case $words[2] in")
+	for _, s := range specs {
+		fmt.Fprintf(w, "  %s)\n", s.name)
+		fmt.Fprintln(w, "    shift words")
+		fmt.Fprintln(w, "    (( CURRENT-- ))")
+		arguments(s, "    ")
+		fmt.Fprintln(w, "    ;;")
+	}
+	fmt.Fprintln(w, "  *)")
+	arguments(specs[0], "    ")
+	fmt.Fprintln(w, "    ;;")
+	fmt.Fprintln(w, "  esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `_re_classify "$@"`)
+}
+
+// fishQuote quotes text for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// writeFishCompletion writes the fish completion script.
+func writeFishCompletion(w io.Writer, specs []completionSpec) {
+	configFiles := fmt.Sprintf("'(__fish_complete_suffix %s)'", strings.Join(configSuffixes, " "))
+	complete := func(condition string, s completionSpec) {
+		for _, f := range s.flags {
+			value := ""
+			if f.takesValue {
+				value = " -r -F"
+			}
+			fmt.Fprintf(w, "complete -c re-classify -n %s -l %s%s -d %s\n", condition, f.name, value, fishQuote(f.usage))
+		}
+		switch {
+		case len(s.words) > 0:
+			fmt.Fprintf(w, "complete -c re-classify -n %s -a %s\n", condition, fishQuote(strings.Join(s.words, " ")))
+		case s.configFile:
+			fmt.Fprintf(w, "complete -c re-classify -n %s -a %s\n", condition, configFiles)
+		}
+	}
+
+	fmt.Fprintln(w, "# fish completion for re-classify")
+	fmt.Fprintln(w, "complete -c re-classify -f")
+	for _, s := range specs {
+		fmt.Fprintf(w, "complete -c re-classify -n __fish_use_subcommand -a %s -d %s\n", s.name, fishQuote(s.summary))
+	}
+	complete("__fish_use_subcommand", specs[0])
+	for _, s := range specs {
+		complete(fishQuote("__fish_seen_subcommand_from "+s.name), s)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+var convertCommand = &command{
+	name:    "convert",
+	args:    "[--to json|yaml] <config>",
+	summary: "Convert a config between YAML and JSON",
+	help: []string{
+		"Print the config in the other format. JSON is a subset of YAML, so",
+		"either can be read. The config is checked before it is converted, and",
+		"deprecated keys are replaced, so --to yaml upgrades a YAML config.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		to := fs.String("to", "json", "Format to convert to: json or yaml")
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if *to != "json" && *to != "yaml" {
+				usageError(fs, "--to must be json or yaml")
+			}
+			data, err := config.ReadSource(args[0])
+			if err != nil {
+				fatal("error reading config", "error", err)
+			}
+			cfg, err := config.ParseClassifierConfig(data)
+			if err == nil {
+				cfg, err = cfg.ResolveExtends(args[0])
+			}
+			if err == nil {
+				_, err = cfg.CompileRegexes()
+			}
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+
+			// Convert the document rather than the ClassifierConfig, so that
+			// absent sections stay absent and the defaults are not spelled out.
+			var node yaml.Node
+			if err := yaml.Unmarshal(data, &node); err != nil {
+				fatal("error reading config", "error", err)
+			}
+			if err := config.UpgradeKeys(&node); err != nil {
+				fatal("error reading config", "error", err)
+			}
+			var doc any
+			if err := node.Decode(&doc); err != nil {
+				fatal("error reading config", "error", err)
+			}
+			if *to == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(doc)
+			} else {
+				enc := yaml.NewEncoder(os.Stdout)
+				enc.SetIndent(2)
+				err = enc.Encode(doc)
+				if err == nil {
+					err = enc.Close()
+				}
+			}
+			if err != nil {
+				fatal("error writing config", "error", err)
+			}
+		}
+	},
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/fuzz"
+)
+
+var fuzzCommand = &command{
+	name:    "fuzz",
+	args:    "<config.yaml> [options]",
+	summary: "Check a config against randomized token streams",
+	help: []string{
+		"Classify randomized token streams, including Unicode edge cases, invalid",
+		"UTF-8 and huge tokens, and report panics, timeouts and classifications that",
+		"change from one run to the next. Exits with status 1 if anything is found.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		iterations := fs.Int("iterations", 1000, "Number of token streams to try")
+		maxTokens := fs.Int("max-tokens", 50, "Maximum number of tokens in a stream")
+		hugeBytes := fs.Int("huge-bytes", 64<<10, "Size in bytes of the huge token given to about one stream in ten")
+		timeout := fs.Duration("timeout", 5*time.Second, "Time allowed for classifying each stream")
+		seed := fs.Uint64("seed", uint64(time.Now().UnixNano()), "Seed for the random streams (default: the current time)")
+		return func(positional []string) {
+			if len(positional) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if *iterations < 1 || *maxTokens < 1 || *hugeBytes < 1 || *timeout <= 0 {
+				usageError(fs, "--iterations, --max-tokens, --huge-bytes and --timeout must be positive")
+			}
+
+			cfg, compiledConfig, err := loadConfig(positional[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			fuzzer, err := fuzz.New(classifier.NewClassifierEngine(compiledConfig), cfg, fuzz.Options{
+				Iterations: *iterations,
+				MaxTokens:  *maxTokens,
+				HugeBytes:  *hugeBytes,
+				Timeout:    *timeout,
+				Seed:       *seed,
+			})
+			if err != nil {
+				fatal("error generating samples", "error", err)
+			}
+
+			stats := fuzzer.Run(func(f fuzz.Failure) {
+				fmt.Println(f)
+			})
+			fmt.Printf("%d streams, %d tokens, %d failures (seed %d)\n", stats.Iterations, stats.Tokens, stats.Failures, *seed)
+			if stats.Failures > 0 {
+				fatal("fuzzing found failures", "count", stats.Failures)
+			}
+		}
+	},
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/gen"
+)
+
+// sectionCodes maps each pattern section onto the code that its patterns are
+// meant to produce.
+var sectionCodes = map[string]string{
+	classifier.SectionSurround:      "S",
+	classifier.SectionFormPrefix:    "P",
+	classifier.SectionSimpleLabel:   "L",
+	classifier.SectionCompoundLabel: "C",
+	classifier.SectionVariable:      "V",
+	classifier.SectionOperator:      "O",
+	classifier.SectionComment:       "#",
+	classifier.SectionString:        "Q",
+	classifier.SectionNumber:        "N",
+}
+
+// genSamples holds the samples generated for one pattern.
+type genSamples struct {
+	section string
+	pattern string
+	samples []string
+}
+
+var genCommand = &command{
+	name:    "gen",
+	args:    "<config.yaml> [options]",
+	summary: "Generate sample tokens for each pattern and classify them",
+	help: []string{
+		"Generate sample tokens matching each pattern of the config and show how",
+		"they are classified. Samples that are not classified by the section they",
+		"were generated from are marked, which exposes patterns that match more",
+		"than intended.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		perPattern := fs.Int("per-pattern", 5, "Number of samples to generate for each pattern")
+		seed := fs.Uint64("seed", 1, "Seed for the random generator, for reproducible samples")
+		return func(positional []string) {
+			if len(positional) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if *perPattern < 1 {
+				usageError(fs, "--per-pattern must be at least 1")
+			}
+
+			cfg, compiledConfig, err := loadConfig(positional[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+
+			g := gen.New(*seed)
+			var all []genSamples
+			var tokens []string
+			for _, section := range config.PatternSections {
+				for _, pattern := range cfg.SectionPatterns(section) {
+					// The generator only knows RE2 syntax, so patterns that
+					// use the features of the pcre engine are skipped.
+					samples, err := g.Samples(pattern, *perPattern)
+					if err != nil {
+						slog.Warn("cannot generate samples", "section", section, "pattern", pattern, "error", err)
+						continue
+					}
+					all = append(all, genSamples{section: section, pattern: pattern, samples: samples})
+					tokens = append(tokens, samples...)
+				}
+			}
+
+			// The samples are classified together, as though they were one input.
+			engine := classifier.NewClassifierEngine(compiledConfig)
+			if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+				fatal("error building form mappings", "error", err)
+			}
+			if err := writeGenReport(os.Stdout, engine, all); err != nil {
+				fatal("error writing output", "error", err)
+			}
+		}
+	},
+}
+
+// writeGenReport lists the samples of each pattern with their classification,
+// marking those that do not get the code of their section.
+func writeGenReport(w io.Writer, engine *classifier.ClassifierEngine, all []genSamples) error {
+	bw := bufio.NewWriter(w)
+	for _, gs := range all {
+		fmt.Fprintf(bw, "%s %q\n", gs.section, gs.pattern)
+		for _, sample := range gs.samples {
+			c := engine.Classify(sample)
+			if want := sectionCodes[gs.section]; c.Code != want {
+				fmt.Fprintf(bw, "  %q\t%s\t(not %s, matched %s %q)\n", sample, c, want, c.Section, c.Pattern)
+			} else {
+				fmt.Fprintf(bw, "  %q\t%s\n", sample, c)
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logOptions holds the logging flags shared by every subcommand.
+type logOptions struct {
+	level  *string
+	format *string
+}
+
+// addLogFlags registers the --log-level and --log-format flags.
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	return &logOptions{
+		level:  fs.String("log-level", "info", "Minimum level of log messages: debug, info, warn or error"),
+		format: fs.String("log-format", "text", "Format of log messages on stderr: text or json"),
+	}
+}
+
+// setup installs the default logger according to the flags. Log messages
+// always go to stderr so they never mix with classifications on stdout.
+func (o *logOptions) setup() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*o.level)); err != nil {
+		fatal("invalid --log-level", "value", *o.level)
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(*o.format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: level,
+			// Timestamps are noise for a command-line tool.
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	default:
+		fatal("invalid --log-format", "value", *o.format)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// atExit holds clean-up actions that fatal runs before exiting, such as
+// discarding a partially written output file.
+var atExit []func()
+
+// fatal logs an error and exits with a non-zero status.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	for _, f := range atExit {
+		f()
+	}
+	os.Exit(1)
+}
+
+// usageError reports a command-line mistake, prints the usage message and
+// exits with a non-zero status.
+func usageError(fs *flag.FlagSet, msg string) {
+	slog.Error(msg)
+	fmt.Fprintln(os.Stderr)
+	fs.Usage()
+	os.Exit(1)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/lsp"
+)
+
+var lspCommand = &command{
+	name:    "lsp",
+	args:    "[options] <config.yaml>",
+	summary: "Run a Language Server providing semantic tokens",
+	help: []string{
+		"Run a Language Server on stdin/stdout that provides semantic tokens",
+		"for monogram files, classified using config.yaml. On SIGINT or SIGTERM",
+		"the server exits once it has answered the message in hand.",
+	},
+	usageToStderr: true,
+	setup: func(fs *flag.FlagSet) func([]string) {
+		gracePeriod := addGracePeriodFlag(fs)
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if args[0] == config.StdinSource {
+				usageError(fs, "the config cannot be read from stdin, which carries the protocol")
+			}
+
+			cfg, compiledConfig, err := loadConfig(args[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+
+			shutdown := notifyShutdown()
+			served := make(chan error, 1)
+			go func() {
+				served <- lsp.NewServer(cfg, compiledConfig).Serve(shutdown, os.Stdin, os.Stdout)
+			}()
+			select {
+			case err = <-served:
+			case <-shutdown.Done():
+				// The server stops once it has answered the message in hand.
+				drained := make(chan struct{})
+				go func() {
+					err = <-served
+					close(drained)
+				}()
+				drain(drained, *gracePeriod)
+			}
+			if err != nil {
+				fatal("language server failed", "error", err)
+			}
+		}
+	},
+}
@@ -2,100 +2,751 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/sfkleach/re-classify/internal/atomicfile"
+	"github.com/sfkleach/re-classify/internal/batch"
 	"github.com/sfkleach/re-classify/internal/classifier"
 	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/highlight"
+	"github.com/sfkleach/re-classify/internal/inputenc"
+	"github.com/sfkleach/re-classify/internal/jsonl"
+	"github.com/sfkleach/re-classify/internal/output"
+	"github.com/sfkleach/re-classify/internal/protocol"
+	"github.com/sfkleach/re-classify/internal/resultcache"
 )
 
 // Version is set at build time via -ldflags
 var Version = "unknown"
 
 func main() {
-	// Define command-line flags
-	checkOnly := flag.Bool("check", false, "Validate configuration syntax only (don't process input)")
-	version := flag.Bool("version", false, "Show version information")
-	echoToStderr := flag.Bool("echo-to-stderr", false, "Echo classification strings to stderr in addition to stdout")
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			cmd.execute(os.Args[2:])
+			return
+		}
+	}
+	runLegacy(os.Args[1:])
+}
+
+var classifyCommand = &command{
+	name:    "classify",
+	args:    "[options] <config.yaml> [-e <token>...]",
+	summary: "Classify tokens read from stdin (the default command)",
+	help: []string{
+		"Read tokens from stdin, one per line, and write their classifications",
+		"according to the regex patterns in config.yaml. The config may also be",
+		"an http(s) URL, optionally pinned with #sha256=<hex>, or - to read it",
+		"from stdin, in which case the tokens are read from --tokens. With -e the",
+		"tokens are the arguments after config.yaml instead, as in:",
+		"",
+		"  re-classify classify config.yaml -e if x endif",
+	},
+	setup: setupClassify,
+}
+
+// setupClassify defines the flags of the classify command.
+func setupClassify(fs *flag.FlagSet) func([]string) {
+	checkOnly := fs.Bool("check", false, "Validate configuration syntax only (don't process input)")
+	version := fs.Bool("version", false, "Show version information")
+	echoToStderr := fs.Bool("echo-to-stderr", false, "Echo classification strings to stderr in addition to stdout")
+	highlightFormat := fs.String("highlight", "", "Render the tokens colorized by class instead of classifying them: ansi or html")
+	cacheDir := fs.String("cache-dir", "", "Cache parsed configurations in this directory, skipping the YAML parse but not the compilation")
+	resultsCache := fs.String("results-cache", "", "Cache the output in this directory, keyed by the config, options and input, so that an identical run returns it at once")
+	noCache := fs.Bool("no-cache", false, "Ignore --cache-dir and --results-cache, e.g. to override them in a script")
+	maxTokenBytes := fs.Int("max-token-bytes", classifier.DefaultMaxTokenBytes, "Maximum length of an input line in bytes")
+	inputFormat := fs.String("input", inputText, "Input format: text, a token per line, or jsonl, a JSON object per line with the token and its file, line and col, which are passed through to --format and --template")
+	inputEncoding := fs.String("input-encoding", inputenc.UTF8, "Encoding of the input: "+strings.Join(inputenc.Names, ", ")+" (a byte order mark is detected automatically)")
+	normalization := fs.String("unicode-normalization", "", "Unicode normal form for tokens and patterns, overriding the config: none, nfc, nfd, nfkc or nfkd")
+	matchStrategy := fs.String("match-strategy", "", "How to choose between patterns of a section that match the same token, overriding the config: first or most-specific")
+	regexEngine := fs.String("regex-engine", "", "The regular expression engine, overriding the config: re2 or pcre")
+	tokenWhitespace := fs.String("token-whitespace", "", "Whether whitespace around a token is part of it, overriding the config: trim or preserve")
+	blankTokens := fs.String("blank-tokens", "", "Whether blank lines are tokens, overriding the config: skip or keep")
+	invalidUTF8 := fs.String("invalid-utf8", "", "What to do with input lines that are not valid UTF-8, overriding the config: pass-through, replace or error")
+	caseFolding := fs.String("case-folding", "", "Case folding of tokens before matching, overriding the config: none or lower")
+	templateText := fs.String("template", "", "Render each token through this Go text/template instead of the protocol format")
+	only := fs.String("only", "", "Only output tokens with these classification codes, comma-separated e.g. U,O")
+	exclude := fs.String("exclude", "", "Omit tokens with these classification codes, comma-separated")
+	skipComments := fs.Bool("skip-comments", false, "Drop comment tokens from the output entirely")
+	showTokens := fs.Bool("show-tokens", false, "Prefix each classification with its token and a tab")
+	unique := fs.Bool("unique", false, "Classify each distinct token once, prefixed with the token and a tab")
+	count := fs.Bool("count", false, "With --unique, prefix each line with the number of occurrences of the token")
+	glob := fs.String("glob", "", "Classify every file matching this glob (** matches any depth) instead of stdin")
+	outDir := fs.String("out-dir", "", "With --glob, the directory for the per-file results and "+batch.SummaryFile)
+	outputPath := fs.String("output", "", "Write the results to this file instead of stdout, replacing it only once complete")
+	longNames := fs.Bool("long-names", false, "Print class names such as form-start instead of single letters")
+	endGroups := fs.Bool("end-groups", false, "Follow each form-end with the surround groups it could close e.g. E 0,3")
+	profilePatterns := fs.Bool("profile-patterns", false, "Report on stderr how many tokens each pattern classified and the time taken")
+	quiet := fs.Bool("quiet", false, "Write no classifications, only warn about unclassified tokens and, with --stateful, impossible nesting, exiting with status 1 if there are any")
+	stateful := fs.Bool("stateful", false, "Track the open forms, attributing each form-end and intermediate to the innermost, and warn about impossible closures")
+	lowMemory := fs.Bool("low-memory", false, "Spool the tokens to a temporary file instead of holding them in memory, for very large inputs")
+	singlePass := fs.Bool("single-pass", false, "Classify each token as soon as it is read, extending the form mappings as form-starts are seen, instead of reading the whole input first")
+	reportConflicts := fs.Bool("report-conflicts", false, "Report the tokens that more than one section matches, and which one wins, instead of classifying them")
+	plan := fs.Bool("plan", false, "Print what building the form mappings from the tokens decided, the endings inferred and patterns backfilled and the final tables, instead of classifying them")
+	trace := fs.Bool("trace", false, "Log the lookup path taken while classifying each token")
+	tokensFile := fs.String("tokens", "", "Read the tokens from this file (e.g. /dev/fd/3) instead of stdin")
+	header := fs.Bool("header", false, "With --format proto or msgpack, start the output with a record of the tool version, config, config fingerprint and time, so that archived results can be traced back to their config")
+	outputFormat := fs.String("format", "text", "Output format: text, length-prefixed binary records in "+strings.Join(output.Formats, " or ")+" as described by proto/classification.proto, or a table in "+strings.Join(output.TableFormats, " or "))
+	protocolVersion := fs.String("protocol", "", "Speak the framed protocol of this version on stdin/stdout instead of classifying a single stream: v1, or v2 which multiplexes sessions with their own configs")
+	fromArgs := fs.Bool("e", false, "Classify the arguments that follow the config file instead of reading tokens")
+
+	return func(args []string) {
+		// Handle version flag
+		if *version {
+			fmt.Printf("re-classify version %s\n", Version)
+			return
+		}
+
+		// Check for required config file argument, followed by the tokens
+		// with -e
+		switch {
+		case *fromArgs && len(args) < 2:
+			usageError(fs, "-e needs a config file followed by at least one token")
+		case !*fromArgs && len(args) != 1:
+			usageError(fs, "exactly one config file must be specified")
+		case *fromArgs && (*tokensFile != "" || *glob != ""):
+			usageError(fs, "-e cannot be combined with --tokens or --glob")
+		}
+
+		switch {
+		case *inputFormat != inputText && *inputFormat != inputJSONL:
+			fatal("invalid option", "error", fmt.Errorf("unknown input %q (expected %s or %s)", *inputFormat, inputText, inputJSONL))
+		case *inputFormat == inputJSONL && (*fromArgs || *glob != "" || *unique || *lowMemory):
+			fatal("invalid option", "error", errors.New("--input jsonl cannot be combined with -e, --glob, --unique or --low-memory"))
+		}
+
+		configFile := args[0]
+		if configFile == config.StdinSource && *tokensFile == "" && *glob == "" && !*checkOnly && !*fromArgs {
+			usageError(fs, "--tokens must be specified when the config is read from stdin")
+		}
+
+		var format highlight.Format
+		if *highlightFormat != "" {
+			var err error
+			format, err = highlight.ParseFormat(*highlightFormat)
+			if err != nil {
+				fatal("invalid option", "error", err)
+			}
+		}
+
+		var tmpl *output.Template
+		if *templateText != "" {
+			var err error
+			tmpl, err = output.ParseTemplate(*templateText)
+			if err != nil {
+				fatal("invalid option", "error", err)
+			}
+		}
+
+		if err := inputenc.Check(*inputEncoding); err != nil {
+			fatal("invalid option", "error", err)
+		}
+
+		if *skipComments {
+			if *exclude != "" {
+				*exclude += ","
+			}
+			*exclude += "#"
+		}
+		filter, err := classifier.NewClassFilter(*only, *exclude)
+		if err != nil {
+			fatal("invalid option", "error", err)
+		}
+
+		// A provenance record ahead of the header row would not be a valid
+		// table, so only the binary formats have one.
+		if *header && (*outputFormat == "text" || slices.Contains(output.TableFormats, *outputFormat)) {
+			fatal("invalid option", "error", errors.New("--header needs --format proto or msgpack"))
+		}
+		var encode output.Encoder
+		if *outputFormat != "text" {
+			encode, err = output.NewEncoder(*outputFormat, *inputFormat == inputJSONL)
+			if err != nil {
+				fatal("invalid option", "error", err)
+			}
+			if format != "" || tmpl != nil || *reportConflicts || *count {
+				fatal("invalid option", "error", errors.New("--format cannot be combined with --highlight, --template, --report-conflicts or --count"))
+			}
+		}
 
-	// Customize usage message
-	flag.Usage = func() {
-		fmt.Printf("Usage: %s [options] <config.yaml>\n\n", os.Args[0])
-		fmt.Println("re-classify is a token classification tool that uses regex patterns")
-		fmt.Println("to classify identifiers and operators in monogram syntax.")
-		fmt.Println("\nOptions:")
-		flag.PrintDefaults()
-		fmt.Println("\nThe tool reads tokens from stdin (one per line) and outputs")
-		fmt.Println("classification results based on the regex patterns in config.yaml")
+		if *stateful && *unique {
+			fatal("invalid option", "error", errors.New("--stateful cannot be combined with --unique"))
+		}
+		if *quiet && (*unique || format != "" || tmpl != nil || *reportConflicts || encode != nil || *outputPath != "" || *echoToStderr || *lowMemory) {
+			fatal("invalid option", "error", errors.New("--quiet cannot be combined with --unique, --highlight, --template, --report-conflicts, --format, --output, --echo-to-stderr or --low-memory"))
+		}
+		if *count && !*unique {
+			fatal("invalid option", "error", errors.New("--count requires --unique"))
+		}
+		if *lowMemory && (*unique || *highlightFormat != "" || *templateText != "" || *reportConflicts || *glob != "") {
+			fatal("invalid option", "error", errors.New("--low-memory cannot be combined with --unique, --highlight, --template, --report-conflicts or --glob"))
+		}
+		if *singlePass && (*lowMemory || *unique || *highlightFormat != "" || *templateText != "" || *reportConflicts || *glob != "") {
+			fatal("invalid option", "error", errors.New("--single-pass cannot be combined with --low-memory, --unique, --highlight, --template, --report-conflicts or --glob"))
+		}
+		if *plan && (*unique || format != "" || tmpl != nil || *reportConflicts || encode != nil || *quiet || *lowMemory || *singlePass || *glob != "" || *protocolVersion != "") {
+			fatal("invalid option", "error", errors.New("--plan cannot be combined with --unique, --highlight, --template, --report-conflicts, --format, --quiet, --low-memory, --single-pass, --glob or --protocol"))
+		}
+		if *quiet && *outDir != "" {
+			fatal("invalid option", "error", errors.New("--quiet cannot be combined with --out-dir"))
+		}
+		if !*quiet && (*glob == "") != (*outDir == "") {
+			fatal("invalid option", "error", errors.New("--glob and --out-dir must be used together"))
+		}
+		if *glob != "" && (format != "" || tmpl != nil || *outputPath != "") {
+			fatal("invalid option", "error", errors.New("--glob cannot be combined with --highlight, --template or --output"))
+		}
+		if *noCache {
+			*cacheDir, *resultsCache = "", ""
+		}
+		// The cache holds stdout, so it cannot replay what is only logged.
+		if *resultsCache != "" && (*glob != "" || *lowMemory || *singlePass || *quiet || *trace || *profilePatterns || *echoToStderr) {
+			fatal("invalid option", "error", errors.New("--results-cache cannot be combined with --glob, --low-memory, --single-pass, --quiet, --trace, --profile-patterns or --echo-to-stderr"))
+		}
+
+		// Load configuration and compile regex patterns
+		cfg, err := loadClassifierConfig(configFile, *cacheDir)
+		if err != nil {
+			fatal("error loading config", "error", err)
+		}
+		// The options that override the config apply to every config
+		// loaded, including those of protocol sessions.
+		override := func(cfg *config.ClassifierConfig) {
+			if *normalization != "" {
+				cfg.UnicodeNormalization = *normalization
+			}
+			if *matchStrategy != "" {
+				cfg.MatchStrategy = *matchStrategy
+			}
+			if *regexEngine != "" {
+				cfg.RegexEngine = *regexEngine
+			}
+			if *tokenWhitespace != "" {
+				cfg.TokenWhitespace = *tokenWhitespace
+			}
+			if *blankTokens != "" {
+				cfg.BlankTokens = *blankTokens
+			}
+			if *invalidUTF8 != "" {
+				cfg.InvalidUTF8 = *invalidUTF8
+			}
+			if *caseFolding != "" {
+				cfg.CaseFolding = *caseFolding
+			}
+		}
+		override(cfg)
+		compiledConfig, err := cfg.CompileRegexes()
+		if err != nil {
+			fatal("error loading config", "error", fmt.Errorf("failed to compile regexes: %w", err))
+		}
+
+		// If check-only mode, just report success and exit
+		if *checkOnly {
+			warnOverlaps(cfg)
+			fmt.Println("Configuration syntax is valid")
+			return
+		}
+
+		// Create classifier engine
+		engine := classifier.NewClassifierEngine(compiledConfig)
+		if *trace {
+			engine.SetTracer(logTrace)
+		}
+		engine.SetReportEndGroups(*endGroups)
+		if *profilePatterns {
+			profile := classifier.NewProfile()
+			engine.SetProfile(profile)
+			defer func() {
+				if err := profile.Write(os.Stderr); err != nil {
+					slog.Warn("error writing pattern profile", "error", err)
+				}
+			}()
+		}
+
+		opts := &classifier.ProcessOptions{
+			MaxTokenBytes: *maxTokenBytes,
+			Filter:        filter,
+			ShowTokens:    *showTokens,
+			Unique:        *unique,
+			Count:         *count,
+			LongNames:     *longNames,
+			Stateful:      *stateful,
+			Quiet:         *quiet,
+			Source:        *tokensFile,
+		}
+		// The positions of the tokens, when the input gives them.
+		var positions []jsonl.Token
+		if encode != nil {
+			opts.Header = output.Header(*outputFormat, *inputFormat == inputJSONL)
+			if *header {
+				provenance, err := newProvenance(configFile, cfg)
+				if err == nil {
+					var prefix string
+					prefix, err = output.ProvenanceHeader(*outputFormat, provenance)
+					opts.Header = prefix + opts.Header
+				}
+				if err != nil {
+					fatal("error writing header", "error", err)
+				}
+			}
+			opts.Encode = func(w io.Writer, position int, token string, c *classifier.Classification) error {
+				return encode(w, withPosition(output.NewRecord(token, c), positions, position))
+			}
+		}
+
+		stdout := io.Writer(os.Stdout)
+		if *outputPath != "" {
+			out, err := atomicfile.Create(*outputPath)
+			if err != nil {
+				fatal("error creating output file", "error", err)
+			}
+			atExit = append(atExit, out.Abort)
+			defer func() {
+				if err := out.Commit(); err != nil {
+					fatal("error writing output file", "error", err)
+				}
+			}()
+			stdout = out
+		}
+
+		// Speak the framed protocol when requested
+		if *protocolVersion != "" {
+			open := func(ref string) (*classifier.ClassifierEngine, *config.ClassifierConfig, error) {
+				if ref == config.StdinSource {
+					return nil, nil, errors.New("the config of a session cannot be read from stdin")
+				}
+				cfg, err := loadClassifierConfig(ref, *cacheDir)
+				if err != nil {
+					return nil, nil, err
+				}
+				override(cfg)
+				compiledConfig, err := cfg.CompileRegexes()
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to compile regexes: %w", err)
+				}
+				engine := classifier.NewClassifierEngine(compiledConfig)
+				if *trace {
+					engine.SetTracer(logTrace)
+				}
+				return engine, cfg, nil
+			}
+			runProtocol(fs, *protocolVersion, engine, cfg, *maxTokenBytes, open)
+			return
+		}
+
+		// Check a batch of files when requested
+		if *glob != "" && *quiet {
+			checkBatch(engine, cfg, opts, *inputEncoding, *glob)
+			return
+		}
+
+		// Classify a batch of files when requested
+		if *glob != "" {
+			runBatch(engine, cfg, opts, *inputEncoding, *glob, *outDir)
+			return
+		}
+
+		// Detecting a byte order mark reads from stdin, so this must wait until
+		// stdin is known to be needed.
+		tokens, encoding := openTokens(*tokensFile), *inputEncoding
+		if *fromArgs {
+			tokens, encoding = strings.NewReader(strings.Join(args[1:], "\n")+"\n"), inputenc.UTF8
+		}
+		input, err := inputenc.NewReader(tokens, encoding)
+		if err != nil {
+			fatal("invalid option", "error", err)
+		}
+
+		// Replay the output of an identical run, or keep this one's. The
+		// header names this run, e.g. by its time, so it is written afresh
+		// rather than cached, and the output goes wherever --output says.
+		if *resultsCache != "" {
+			data, err := io.ReadAll(input)
+			if err != nil {
+				fatal("error reading tokens", "error", err)
+			}
+			input = bytes.NewReader(data)
+			key, err := resultsKey(fs, cfg, data)
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			cache := &resultcache.Cache{Dir: *resultsCache}
+			if cached, ok, err := cache.Get(key); err != nil {
+				slog.Warn("could not read the results cache", "error", err)
+			} else if ok {
+				slog.Debug("replayed results from cache", "key", key)
+				_, err := io.WriteString(stdout, opts.Header)
+				if err == nil {
+					_, err = stdout.Write(cached)
+				}
+				if err != nil {
+					fatal("error writing output", "error", err)
+				}
+				return
+			}
+			var kept bytes.Buffer
+			stdout = io.MultiWriter(stdout, &kept)
+			// A failed run exits without storing anything.
+			defer func() {
+				body, ok := bytes.CutPrefix(kept.Bytes(), []byte(opts.Header))
+				if !ok {
+					return
+				}
+				if err := cache.Put(key, body); err != nil {
+					slog.Warn("could not write the results cache", "error", err)
+				}
+			}()
+		}
+		if *inputFormat == inputJSONL {
+			positions, err = jsonl.Read(input)
+			if err != nil {
+				fatal("error reading tokens", "error", err)
+			}
+			input = strings.NewReader(jsonl.Lines(positions))
+		}
+
+		// Report conflicts between sections when requested
+		if *reportConflicts {
+			n, err := engine.WriteConflicts(stdout, readAndBuild(engine, cfg, input, *maxTokenBytes))
+			if err != nil {
+				fatal("error writing output", "error", err)
+			}
+			slog.Debug("reported conflicts", "tokens", n)
+			return
+		}
+
+		// Report how the form mappings were built when requested
+		if *plan {
+			tokens, err := classifier.ReadTokensWith(input, *maxTokenBytes, engine.TokenPolicy())
+			if err != nil {
+				fatalReadError(err)
+			}
+			p, err := engine.PlanFormStartEndMappings(tokens, cfg)
+			if err != nil {
+				fatal("error building form mappings", "error", err)
+			}
+			if err := p.Write(stdout); err != nil {
+				fatal("error writing output", "error", err)
+			}
+			return
+		}
+
+		// Render highlighted tokens when requested
+		if format != "" {
+			var shown, codes []string
+			var prev *classifier.Classification
+			nesting := engine.NewNesting()
+			for _, token := range readAndBuild(engine, cfg, input, *maxTokenBytes) {
+				prev = engine.ClassifyAfter(prev, token)
+				// Resolving attributes the form-ends to their forms, as
+				// classify does, and warns about impossible nesting.
+				c := prev
+				if *stateful {
+					c = nesting.Resolve(token, c)
+				}
+				if code := c.Code; filter.Allows(code) {
+					shown = append(shown, token)
+					codes = append(codes, code)
+				}
+			}
+			if *stateful {
+				nesting.Finish()
+			}
+			if err := highlight.Write(stdout, format, shown, codes); err != nil {
+				fatal("error writing output", "error", err)
+			}
+			return
+		}
+
+		// Render each token through the template when requested
+		if tmpl != nil {
+			tokens := readAndBuild(engine, cfg, input, *maxTokenBytes)
+			if *unique {
+				tokens, _ = classifier.UniqueTokens(tokens)
+			}
+			out := bufio.NewWriter(stdout)
+			var prev *classifier.Classification
+			nesting := engine.NewNesting()
+			for i, token := range tokens {
+				var c *classifier.Classification
+				if *unique {
+					c = engine.Classify(token)
+				} else {
+					c = engine.ClassifyAfter(prev, token)
+					prev = c
+				}
+				if *stateful {
+					c = nesting.Resolve(token, c)
+				}
+				if !filter.Allows(c.Code) {
+					continue
+				}
+				if err := tmpl.Write(out, withPosition(output.NewRecord(token, c), positions, i+1)); err != nil {
+					fatal("error writing output", "error", err)
+				}
+			}
+			if *stateful {
+				nesting.Finish()
+			}
+			if err := out.Flush(); err != nil {
+				fatal("error writing output", "error", err)
+			}
+			return
+		}
+
+		// Process tokens and output classifications
+		if *echoToStderr {
+			// Echoed lines must interleave sensibly with stdout.
+			opts.Echo = os.Stderr
+			opts.Unbuffered = true
+		}
+		switch {
+		case *singlePass:
+			err = engine.ProcessSinglePass(input, stdout, cfg, opts)
+		case *lowMemory:
+			err = engine.ProcessSpooled(input, stdout, cfg, opts, "")
+		default:
+			err = engine.Process(input, stdout, cfg, opts)
+		}
+		if err != nil {
+			fatalReadError(err)
+		}
 	}
+}
 
-	// Parse command-line flags
-	flag.Parse()
+// The input formats.
+const (
+	inputText  = "text"
+	inputJSONL = "jsonl"
+)
+
+// uncachedFlags are the flags of classify that do not change its output, and
+// so are left out of the results cache key. The tokens are part of the key
+// whichever file they are read from.
+var uncachedFlags = []string{"cache-dir", "results-cache", "no-cache", "output", "tokens", "log-level", "log-format"}
 
-	// Handle version flag
-	if *version {
-		fmt.Printf("re-classify version %s\n", Version)
-		return
+// resultsKey identifies the output of a run: the version of re-classify, the
+// settings of the config and the files that it reads, the options that shape
+// the output and the input.
+func resultsKey(fs *flag.FlagSet, cfg *config.ClassifierConfig, input []byte) (*resultcache.Key, error) {
+	fingerprint, err := cfg.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	key := resultcache.NewKey().AddString(Version).AddString(fingerprint)
+	for _, source := range cfg.ExternalSources() {
+		data, err := config.ReadSource(source)
+		if err != nil {
+			return nil, err
+		}
+		key.AddString(source).Add(data)
 	}
+	fs.Visit(func(f *flag.Flag) {
+		if !slices.Contains(uncachedFlags, f.Name) {
+			key.AddString(f.Name + "=" + f.Value.String())
+		}
+	})
+	return key.Add(input), nil
+}
 
-	// Check for required config file argument
-	args := flag.Args()
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "Error: exactly one config file must be specified\n\n")
-		flag.Usage()
-		os.Exit(1)
+// newProvenance identifies this run for --header. The time is taken from
+// SOURCE_DATE_EPOCH when it is set, for reproducible output.
+func newProvenance(configFile string, cfg *config.ClassifierConfig) (output.Provenance, error) {
+	fingerprint, err := cfg.Fingerprint()
+	if err != nil {
+		return output.Provenance{}, err
 	}
+	now := time.Now()
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return output.Provenance{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", epoch)
+		}
+		now = time.Unix(seconds, 0)
+	}
+	return output.Provenance{ToolVersion: Version, Config: configFile, ConfigHash: fingerprint, Timestamp: now}, nil
+}
+
+// withPosition adds to the record of a token its position in the source, if
+// the input gave one. The position of the token in the input counts from 1.
+func withPosition(r output.Record, positions []jsonl.Token, position int) output.Record {
+	if position <= len(positions) {
+		p := positions[position-1]
+		r.File, r.Line, r.Col = p.File, p.Line, p.Col
+	}
+	return r
+}
 
-	configFile := args[0]
+// protocolFlags are the flags that may be combined with --protocol. The rest
+// shape the output, which the protocol negotiates instead.
+var protocolFlags = []string{
+	"protocol", "cache-dir", "max-token-bytes", "unicode-normalization",
+	"match-strategy", "regex-engine", "token-whitespace", "invalid-utf8", "case-folding",
+	"trace", "log-level", "log-format",
+}
 
-	// Load configuration
-	cfg, err := config.LoadClassifierConfig(configFile)
+// runProtocol speaks the framed protocol on stdin/stdout. Version v2 opens
+// the configs of its sessions with open.
+func runProtocol(fs *flag.FlagSet, version string, engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, maxTokenBytes int, open protocol.Opener) {
+	if version != "v1" && version != "v2" {
+		fatal("invalid option", "error", fmt.Errorf("unknown protocol %q (expected v1 or v2)", version))
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if !slices.Contains(protocolFlags, f.Name) {
+			fatal("invalid option", "error", fmt.Errorf("--protocol cannot be combined with --%s", f.Name))
+		}
+	})
+	fingerprint, err := cfg.Fingerprint()
+	if err != nil {
+		fatal("error fingerprinting config", "error", err)
+	}
+	info := protocol.Info{ToolVersion: Version, Config: fingerprint}
+	if version == "v2" {
+		err = protocol.ServeSessions(os.Stdin, os.Stdout, engine, cfg, info, maxTokenBytes, open)
+	} else {
+		err = protocol.Serve(os.Stdin, os.Stdout, engine, cfg, info, maxTokenBytes)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-		os.Exit(1)
+		fatal("protocol error", "error", err)
 	}
+}
 
-	// Compile regex patterns
-	compiledConfig, err := cfg.CompileRegexes()
+// runBatch classifies every file matching the glob, writing the results and a
+// summary to outDir.
+func runBatch(engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, opts *classifier.ProcessOptions, encoding, glob, outDir string) {
+	files, err := batch.Glob(glob)
+	if err != nil {
+		fatal("error finding input files", "error", err)
+	}
+	if len(files) == 0 {
+		fatal("no input files match the glob", "glob", glob)
+	}
+	runner := &batch.Runner{Engine: engine, Config: cfg, Options: opts, Encoding: encoding}
+	results, err := runner.Run(files, batch.Base(glob), outDir)
+	if err != nil {
+		fatalReadError(err)
+	}
+	summary, err := atomicfile.Create(filepath.Join(outDir, batch.SummaryFile))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error compiling regexes: %v\n", err)
-		os.Exit(1)
+		fatal("error writing summary", "error", err)
+	}
+	atExit = append(atExit, summary.Abort)
+	if err := batch.WriteSummary(summary, results); err != nil {
+		fatal("error writing summary", "error", err)
+	}
+	if err := summary.Commit(); err != nil {
+		fatal("error writing summary", "error", err)
 	}
+	slog.Info("classified files", "count", len(files), "out-dir", outDir)
+}
 
-	// If check-only mode, just report success and exit
-	if *checkOnly {
-		fmt.Println("Configuration syntax is valid")
-		return
+// checkBatch classifies every file matching the glob in quiet mode, exiting
+// with a non-zero status if any of them has problems.
+func checkBatch(engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, opts *classifier.ProcessOptions, encoding, glob string) {
+	files, err := batch.Glob(glob)
+	if err != nil {
+		fatal("error finding input files", "error", err)
 	}
+	if len(files) == 0 {
+		fatal("no input files match the glob", "glob", glob)
+	}
+	runner := &batch.Runner{Engine: engine, Config: cfg, Options: opts, Encoding: encoding}
+	problems, err := runner.Check(files)
+	if err != nil {
+		fatalReadError(err)
+	}
+	if problems > 0 {
+		fatal("input has problems", "problems", problems, "files", len(files))
+	}
+}
 
-	// Create classifier engine
-	engine := classifier.NewClassifierEngine(compiledConfig)
+// readAndBuild reads the tokens and builds the form mappings from them.
+func readAndBuild(engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, input io.Reader, maxTokenBytes int) []string {
+	tokens, err := classifier.ReadTokensWith(input, maxTokenBytes, engine.TokenPolicy())
+	if err != nil {
+		fatalReadError(err)
+	}
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		fatal("error building form mappings", "error", err)
+	}
+	return tokens
+}
 
-	// Read tokens from stdin
-	scanner := bufio.NewScanner(os.Stdin)
-	var tokens []string
+// openTokens opens the file named by --tokens, or returns stdin if there is
+// none.
+func openTokens(tokensFile string) io.Reader {
+	if tokensFile == "" {
+		return os.Stdin
+	}
+	f, err := os.Open(tokensFile) // #nosec G304, this is a CLI application.
+	if err != nil {
+		fatal("error opening tokens", "error", err)
+	}
+	return f
+}
 
-	for scanner.Scan() {
-		token := strings.TrimSpace(scanner.Text())
-		if token != "" {
-			tokens = append(tokens, token)
-		}
+// fatalReadError reports a failure to process the input, with a hint when a
+// token was too long, or the problems found in quiet mode.
+func fatalReadError(err error) {
+	var tooLong *classifier.TokenTooLongError
+	if errors.As(err, &tooLong) {
+		fatal("error reading tokens", "error", err, "hint", "use --max-token-bytes to raise the limit")
 	}
+	var invalid *classifier.InvalidUTF8Error
+	if errors.As(err, &invalid) {
+		fatal("error reading tokens", "error", err, "hint", "use --input-encoding to decode it, or --invalid-utf8 replace")
+	}
+	var problems *classifier.ProblemsError
+	if errors.As(err, &problems) {
+		fatal("input has problems", "problems", problems.Problems)
+	}
+	fatal("error processing tokens", "error", err)
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading from stdin: %v\n", err)
-		os.Exit(1)
+// loadConfig loads a configuration file and compiles its regex patterns.
+func loadConfig(configFile string) (*config.ClassifierConfig, *config.CompiledClassifierConfig, error) {
+	cfg, err := loadClassifierConfig(configFile, "")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Build form-start to form-end mappings by analyzing all tokens
-	err = engine.BuildFormStartEndMappings(tokens, cfg)
+	compiledConfig, err := cfg.CompileRegexes()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error building form mappings: %v\n", err)
-		os.Exit(1)
+		return nil, nil, fmt.Errorf("failed to compile regexes: %w", err)
+	}
+
+	return cfg, compiledConfig, nil
+}
+
+// logTrace logs each step of the lookup path for a token.
+func logTrace(trace *classifier.Trace) {
+	for _, step := range trace.Steps {
+		if step.ExceptedBy != "" {
+			slog.Info("trace", "token", trace.Token, "table", step.Table, "result", "excepted", "pattern", step.Pattern, "except", step.ExceptedBy)
+		} else if step.Hit {
+			slog.Info("trace", "token", trace.Token, "table", step.Table, "result", "hit", "pattern", step.Pattern, "groups", step.CaptureGroups)
+		} else {
+			slog.Info("trace", "token", trace.Token, "table", step.Table, "result", "miss")
+		}
 	}
+}
 
-	// Process tokens and output classifications
-	engine.ProcessTokens(tokens, *echoToStderr)
+// loadClassifierConfig loads a configuration file, using the config cache in
+// cacheDir if it is not empty.
+func loadClassifierConfig(configFile, cacheDir string) (*config.ClassifierConfig, error) {
+	if cacheDir != "" {
+		return config.LoadClassifierConfigCached(configFile, cacheDir)
+	}
+	return config.LoadClassifierConfig(configFile)
 }
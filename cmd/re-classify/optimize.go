@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sfkleach/re-classify/internal/atomicfile"
+	"github.com/sfkleach/re-classify/internal/batch"
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/inputenc"
+	"github.com/sfkleach/re-classify/internal/optimize"
+)
+
+var optimizeCommand = &command{
+	name:    "optimize",
+	args:    "--corpus <glob> [options] <config.yaml>",
+	summary: "Order the patterns of a config by how often they match",
+	help: []string{
+		"Count how often each pattern matches the tokens of a training corpus and",
+		"propose declaring the patterns of each section in order of frequency, as a",
+		"canonical order that puts the common ones first. Each section is matched in",
+		"a single pass, so the order does not affect the speed of classification.",
+		"Orders that would change how any corpus or sample token is classified are",
+		"rejected. The surround-regexp and operator-regexp sections keep their order,",
+		"which sets their serial numbers.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		corpus := fs.String("corpus", "", "Glob of the training token files, where ** matches any number of directories")
+		write := fs.String("write", "", "Write the reordered config to this file, which may be the config itself, or - for stdout")
+		seed := fs.Uint64("seed", 1, "Seed for the samples used to check that the new order is safe")
+		return func(positional []string) {
+			if len(positional) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if *corpus == "" {
+				usageError(fs, "--corpus must be specified")
+			}
+			configFile := positional[0]
+
+			// The text is kept for rewriting, so the config is read once
+			// even when it comes from stdin or a URL.
+			data, err := config.ReadSource(configFile)
+			if err != nil {
+				fatal("error loading config", "error", fmt.Errorf("failed to read config file %s: %w", configFile, err))
+			}
+			cfg, err := config.ParseClassifierConfig(data)
+			if err != nil {
+				fatal("error loading config", "error", fmt.Errorf("failed to parse config file %s: %w", configFile, err))
+			}
+			// The patterns of the configs that it extends are not in the
+			// text, so it cannot be reordered.
+			if cfg.Extends != "" && *write != "" {
+				fatal("invalid option", "error", errors.New("--write cannot rewrite a config that uses extends"))
+			}
+			if cfg, err = cfg.ResolveExtends(configFile); err != nil {
+				fatal("error loading config", "error", err)
+			}
+			policy, err := cfg.TokenPolicy()
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			tokens, err := readCorpus(*corpus, policy)
+			if err != nil {
+				fatalReadError(err)
+			}
+			plan, err := optimize.Analyze(cfg, tokens, *seed)
+			if err != nil {
+				fatal("error analyzing config", "error", err)
+			}
+			writePlan(plan)
+
+			if *write != "" {
+				rewritten, err := optimize.Rewrite(data, plan)
+				if err != nil {
+					fatal("error rewriting config", "error", err)
+				}
+				if *write == "-" {
+					if _, err := os.Stdout.Write(rewritten); err != nil {
+						fatal("error writing config", "error", err)
+					}
+					return
+				}
+				out, err := atomicfile.Create(*write)
+				if err != nil {
+					fatal("error writing config", "error", err)
+				}
+				atExit = append(atExit, out.Abort)
+				if _, err := out.Write(rewritten); err != nil {
+					fatal("error writing config", "error", err)
+				}
+				if err := out.Commit(); err != nil {
+					fatal("error writing config", "error", err)
+				}
+			}
+		}
+	},
+}
+
+// readCorpus reads the tokens of every file matching the glob as a single
+// stream, following the policy.
+func readCorpus(glob string, policy config.TokenPolicy) ([]string, error) {
+	files, err := batch.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %q", glob)
+	}
+	var tokens []string
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		input, err := inputenc.NewReader(f, inputenc.UTF8)
+		if err == nil {
+			var t []string
+			t, err = classifier.ReadTokensWith(input, classifier.DefaultMaxTokenBytes, policy)
+			tokens = append(tokens, t...)
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return tokens, nil
+}
+
+// writePlan reports the analysis of each section.
+func writePlan(plan *optimize.Plan) {
+	for _, s := range plan.Sections {
+		switch {
+		case s.Order == nil:
+			fmt.Printf("%s: already in order\n", s.Name)
+			continue
+		case s.Changed > 0:
+			fmt.Printf("%s: not reordered, the patterns overlap and %d tokens would match differently\n", s.Name, s.Changed)
+			continue
+		}
+		fmt.Printf("%s: reordered by frequency\n", s.Name)
+		for rank, i := range s.Order {
+			fmt.Printf("  %d. %q: %d hits (was %d)\n", rank+1, s.Patterns[i], s.Hits[i], i+1)
+		}
+	}
+	fmt.Printf("%d tokens\n", plan.Tokens)
+}
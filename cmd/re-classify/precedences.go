@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+var precedencesCommand = &command{
+	name:    "precedences",
+	args:    "[options] <config.yaml>",
+	summary: "Print the operators of a config as a precedence table",
+	help: []string{
+		"Print a table of the operators of config.yaml in ascending order of",
+		"precedence, with sample tokens that each operator pattern matches, for",
+		"documenting a language and checking its precedences. A precedence of 0,",
+		"shown as -, means that the operator cannot appear in that position;",
+		"such operators come last.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		by := fs.String("by", config.PositionInfix, "Sort by the precedence in this position: prefix, infix or postfix")
+		format := fs.String("format", "text", "Output format: text or json")
+		samples := fs.Int("samples", 3, "Number of sample tokens to show for each operator")
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if *format != "text" && *format != "json" {
+				usageError(fs, "--format must be text or json")
+			}
+			if *samples < 0 {
+				usageError(fs, "--samples must not be negative")
+			}
+			cfg, _, err := loadConfig(args[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			table, err := cfg.Precedences(*by, *samples)
+			if err != nil {
+				fatal("invalid option", "error", err)
+			}
+			if *format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(table)
+			} else {
+				err = writePrecedences(os.Stdout, table)
+			}
+			if err != nil {
+				fatal("error writing output", "error", err)
+			}
+		}
+	},
+}
+
+// writePrecedences writes a precedence table as aligned columns.
+func writePrecedences(w io.Writer, table []config.Precedence) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tINFIX\tPOSTFIX\tPATTERN\tSAMPLES")
+	for _, p := range table {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", precText(p.PrefixPrec), precText(p.InfixPrec), precText(p.PostfixPrec), p.Pattern, strings.Join(p.Samples, " "))
+	}
+	return tw.Flush()
+}
+
+// precText shows a precedence, or - for an operator that cannot appear in
+// the position.
+func precText(prec uint16) string {
+	if prec == 0 {
+		return "-"
+	}
+	return strconv.Itoa(int(prec))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// replSession holds the state of an interactive session. The tokens entered
+// so far are remembered so that the form-start/form-end mappings can be
+// rebuilt exactly as they would be for the same input in batch mode.
+type replSession struct {
+	configFile string
+	cfg        *config.ClassifierConfig
+	engine     *classifier.ClassifierEngine
+	history    []string
+	out        io.Writer
+}
+
+var replCommand = &command{
+	name:    "repl",
+	args:    "[options] <config.yaml>",
+	summary: "Classify tokens interactively",
+	help: []string{
+		"Interactively classify tokens, one per line. Type :help for a list of commands.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if args[0] == config.StdinSource {
+				usageError(fs, "the config cannot be read from stdin, which carries commands")
+			}
+
+			session := &replSession{configFile: args[0], out: os.Stdout}
+			if err := session.reload(); err != nil {
+				fatal("error loading config", "error", err)
+			}
+
+			scanner := bufio.NewScanner(os.Stdin)
+			for {
+				fmt.Fprint(session.out, "> ")
+				if !scanner.Scan() {
+					break
+				}
+				if !session.handleLine(strings.TrimSpace(scanner.Text())) {
+					return
+				}
+			}
+			fmt.Fprintln(session.out)
+
+			if err := scanner.Err(); err != nil {
+				fatal("error reading from stdin", "error", err)
+			}
+		}
+	},
+}
+
+// reload (re)reads and compiles the configuration file. On failure the
+// previous configuration, if any, stays in effect.
+func (s *replSession) reload() error {
+	cfg, compiledConfig, err := loadConfig(s.configFile)
+	if err != nil {
+		return err
+	}
+	engine := classifier.NewClassifierEngine(compiledConfig)
+	if err := engine.BuildFormStartEndMappings(s.history, cfg); err != nil {
+		return err
+	}
+	s.cfg, s.engine = cfg, engine
+	return nil
+}
+
+// remember adds the token to the history and rebuilds the mappings. On
+// failure the token is forgotten and the engine left as it was, so that it
+// does not spoil later tokens.
+func (s *replSession) remember(token string) error {
+	return s.rebuild(append(slices.Clone(s.history), token))
+}
+
+// rebuild builds the mappings for the history in a clone of the engine and
+// adopts both only if that succeeds, as reload does.
+func (s *replSession) rebuild(history []string) error {
+	engine := s.engine.Clone()
+	if err := engine.BuildFormStartEndMappings(history, s.cfg); err != nil {
+		return err
+	}
+	s.history, s.engine = history, engine
+	return nil
+}
+
+// handleLine processes one line of input, returning false when the session
+// should end.
+func (s *replSession) handleLine(line string) bool {
+	if line == "" {
+		return true
+	}
+	if !strings.HasPrefix(line, ":") {
+		if err := s.remember(line); err != nil {
+			fmt.Fprintf(s.out, "Error: %v\n", err)
+			return true
+		}
+		c := s.engine.Classify(line)
+		if c.Section == "" {
+			fmt.Fprintln(s.out, c.String())
+		} else {
+			fmt.Fprintf(s.out, "%s\t(%s: %s)\n", c.String(), c.Section, c.Pattern)
+		}
+		return true
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case ":quit", ":q":
+		return false
+	case ":help", ":h":
+		fmt.Fprintln(s.out, "Enter a token to classify it, or one of these commands:")
+		fmt.Fprintln(s.out, "  :explain TOKEN   Show in detail how TOKEN is classified")
+		fmt.Fprintln(s.out, "  :reload          Re-read the configuration file")
+		fmt.Fprintln(s.out, "  :reset           Forget the tokens entered so far")
+		fmt.Fprintln(s.out, "  :history         List the tokens entered so far")
+		fmt.Fprintln(s.out, "  :quit            Exit the REPL")
+	case ":explain", ":e":
+		if arg == "" {
+			fmt.Fprintln(s.out, "Usage: :explain TOKEN")
+			break
+		}
+		if err := s.remember(arg); err != nil {
+			fmt.Fprintf(s.out, "Error: %v\n", err)
+			break
+		}
+		s.explain(arg)
+	case ":reload", ":r":
+		if err := s.reload(); err != nil {
+			fmt.Fprintf(s.out, "Error: %v (keeping previous configuration)\n", err)
+		} else {
+			fmt.Fprintf(s.out, "Reloaded %s\n", s.configFile)
+		}
+	case ":reset":
+		if err := s.rebuild(nil); err != nil {
+			fmt.Fprintf(s.out, "Error: %v\n", err)
+		}
+	case ":history":
+		for _, token := range s.history {
+			fmt.Fprintln(s.out, token)
+		}
+	default:
+		fmt.Fprintf(s.out, "Unknown command %s, try :help\n", command)
+	}
+	return true
+}
+
+// explain prints a detailed breakdown of the classification of a token.
+func (s *replSession) explain(token string) {
+	c := s.engine.Classify(token)
+	fmt.Fprintf(s.out, "token:          %s\n", token)
+	fmt.Fprintf(s.out, "classification: %s\n", c.String())
+	if c.Section == "" {
+		fmt.Fprintln(s.out, "matched:        no pattern in any section")
+		return
+	}
+	fmt.Fprintf(s.out, "section:        %s\n", c.Section)
+	fmt.Fprintf(s.out, "pattern:        %s\n", c.Pattern)
+	for i, group := range c.CaptureGroups {
+		fmt.Fprintf(s.out, "$%d:             %s\n", i, group)
+	}
+}
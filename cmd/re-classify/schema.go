@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+var schemaCommand = &command{
+	name:    "schema",
+	args:    "",
+	summary: "Print a JSON Schema of the config file format",
+	help: []string{
+		"Print a JSON Schema describing the config file format, for editors that",
+		"validate and complete YAML against a schema.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		return func(args []string) {
+			if len(args) != 0 {
+				usageError(fs, "schema takes no arguments")
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(config.Schema()); err != nil {
+				fatal("error writing schema", "error", err)
+			}
+		}
+	},
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sfkleach/re-classify/internal/server"
+)
+
+var serveCommand = &command{
+	name:    "serve",
+	args:    "[options] <config.yaml>",
+	summary: "Serve classifications over HTTP",
+	help: []string{
+		"Serve classifications over HTTP. POST tokens (one per line) to /classify;",
+		"metrics are available from /metrics. Send SIGHUP to reload the config.",
+		"On SIGINT or SIGTERM the server stops accepting connections and exits once",
+		"the requests in progress have finished.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		listen := fs.String("listen", "localhost:8080", "Address to listen on")
+		gracePeriod := addGracePeriodFlag(fs)
+		return func(args []string) {
+			if len(args) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+
+			srv, err := server.New(args[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+
+			// Reload the configuration on SIGHUP
+			hangups := make(chan os.Signal, 1)
+			signal.Notify(hangups, syscall.SIGHUP)
+			go func() {
+				for range hangups {
+					if err := srv.Reload(); err != nil {
+						slog.Error("error reloading config, keeping previous configuration", "error", err)
+					} else {
+						slog.Info("reloaded config", "file", args[0])
+					}
+				}
+			}()
+
+			httpServer := &http.Server{Addr: *listen, Handler: srv.Handler()}
+			shutdown := notifyShutdown()
+			drained := make(chan struct{})
+			go func() {
+				<-shutdown.Done()
+				// Shutdown closes the listeners and then waits for the
+				// requests in progress, which drain bounds.
+				if err := httpServer.Shutdown(context.Background()); err != nil {
+					slog.Error("error shutting down", "error", err)
+				}
+				close(drained)
+			}()
+
+			slog.Info("listening", "address", *listen)
+			if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				fatal("server failed", "error", err)
+			}
+			drain(drained, *gracePeriod)
+		}
+	},
+}
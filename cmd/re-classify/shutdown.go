@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultGracePeriod is shorter than the 30 seconds that Kubernetes allows by
+// default, so that a drain finishes before the pod is killed.
+const defaultGracePeriod = 25 * time.Second
+
+// addGracePeriodFlag registers the --grace-period flag of the long-running
+// commands.
+func addGracePeriodFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("grace-period", defaultGracePeriod, "Time allowed for in-flight work to finish after SIGINT or SIGTERM")
+}
+
+// notifyShutdown returns a context that is cancelled by SIGINT or SIGTERM.
+// After the first signal the default handling is restored, so that a second
+// one ends the process at once.
+func notifyShutdown() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, func() {
+		stop()
+		slog.Info("shutting down")
+	})
+	return ctx
+}
+
+// drain waits up to the grace period for done to be closed once shutdown has
+// begun, and fails if it is not. The exit status is 0 for a clean drain and
+// 1 if the grace period runs out.
+func drain(done <-chan struct{}, gracePeriod time.Duration) {
+	select {
+	case <-done:
+		slog.Info("shut down cleanly")
+	case <-time.After(gracePeriod):
+		fatal("work still in progress at the end of the grace period", "grace-period", gracePeriod)
+	}
+}
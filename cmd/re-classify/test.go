@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/batch"
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/inputenc"
+)
+
+var testCommand = &command{
+	name:    "test",
+	args:    "--golden <dir> [options] <config.yaml>",
+	summary: "Compare classifications with golden files",
+	help: []string{
+		"Classify every tokens file beneath the directory and compare the result with",
+		"the expected file of the same name, reporting the differences. Exits with",
+		"status 1 if any file differs or has no expected file.",
+	},
+	setup: func(fs *flag.FlagSet) func([]string) {
+		golden := fs.String("golden", "", "Directory of *"+batch.TokensSuffix+" files to compare with their *"+batch.ExpectedSuffix+" files")
+		update := fs.Bool("update", false, "Write the *"+batch.ExpectedSuffix+" files from the current classifications instead of comparing")
+		maxTokenBytes := fs.Int("max-token-bytes", classifier.DefaultMaxTokenBytes, "Maximum length of an input line in bytes")
+		inputEncoding := fs.String("input-encoding", inputenc.UTF8, "Encoding of the tokens files: "+strings.Join(inputenc.Names, ", "))
+		return func(positional []string) {
+			if len(positional) != 1 {
+				usageError(fs, "exactly one config file must be specified")
+			}
+			if *golden == "" {
+				usageError(fs, "--golden must be specified")
+			}
+			if err := inputenc.Check(*inputEncoding); err != nil {
+				fatal("invalid option", "error", err)
+			}
+
+			cfg, compiledConfig, err := loadConfig(positional[0])
+			if err != nil {
+				fatal("error loading config", "error", err)
+			}
+			runner := &batch.Runner{
+				Engine:   classifier.NewClassifierEngine(compiledConfig),
+				Config:   cfg,
+				Options:  &classifier.ProcessOptions{MaxTokenBytes: *maxTokenBytes},
+				Encoding: *inputEncoding,
+			}
+			results, err := runner.Golden(*golden, *update)
+			if err != nil {
+				fatalReadError(err)
+			}
+			if len(results) == 0 {
+				fatal("no tokens files found", "dir", *golden)
+			}
+
+			counts := make(map[string]int)
+			for _, result := range results {
+				counts[result.Outcome]++
+				fmt.Printf("%s %s\n", result.Outcome, result.File)
+				for _, line := range result.Diff {
+					fmt.Printf("    %s\n", line)
+				}
+			}
+			fmt.Printf("%d passed, %d failed, %d missing, %d updated\n",
+				counts[batch.GoldenPass], counts[batch.GoldenFail], counts[batch.GoldenMissing], counts[batch.GoldenUpdated])
+			if n := counts[batch.GoldenFail] + counts[batch.GoldenMissing]; n > 0 {
+				fatal("golden tests failed", "count", n, "hint", "use --update to accept the current classifications")
+			}
+		}
+	},
+}
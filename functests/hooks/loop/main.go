@@ -0,0 +1,37 @@
+//go:build wasip1
+
+// Command loop is a hook module that never returns from classifying a token
+// that starts with "loop", for checking that hooks are given a time limit.
+// Build it with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o loop.wasm ./functests/hooks/loop
+package main
+
+import "unsafe"
+
+// buffer holds the token being classified.
+var buffer []byte
+
+// spins counts the iterations of the loop, so that it is not optimized away.
+var spins int
+
+//go:wasmexport alloc
+func alloc(n int32) int32 {
+	if cap(buffer) < int(n) {
+		buffer = make([]byte, n)
+	}
+	buffer = buffer[:n]
+	return int32(uintptr(unsafe.Pointer(unsafe.SliceData(buffer))))
+}
+
+//go:wasmexport classify
+func classify(ptr, n, code int32) int32 {
+	if string(buffer) != "loop" {
+		return 0
+	}
+	for {
+		spins++
+	}
+}
+
+func main() {}
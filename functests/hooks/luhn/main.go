@@ -0,0 +1,51 @@
+//go:build wasip1
+
+// Command luhn is a hook module that vetoes the numbers of 12 or more digits
+// that fail the Luhn checksum, as card numbers would. Build it with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o luhn.wasm ./functests/hooks/luhn
+package main
+
+import "unsafe"
+
+// buffer holds the token being classified.
+var buffer []byte
+
+//go:wasmexport alloc
+func alloc(n int32) int32 {
+	if cap(buffer) < int(n) {
+		buffer = make([]byte, n)
+	}
+	buffer = buffer[:n]
+	return int32(uintptr(unsafe.Pointer(unsafe.SliceData(buffer))))
+}
+
+//go:wasmexport classify
+func classify(ptr, n, code int32) int32 {
+	if code != 'N' || len(buffer) < 12 || luhn(buffer) {
+		return 0
+	}
+	return 'U'
+}
+
+// luhn reports whether a string of digits has a valid Luhn check digit. A
+// string with other characters does not.
+func luhn(digits []byte) bool {
+	sum := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if d < '0' || d > '9' {
+			return false
+		}
+		n := int(d - '0')
+		if i%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return sum%10 == 0
+}
+
+func main() {}
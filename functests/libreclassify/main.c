@@ -0,0 +1,84 @@
+/*
+ * Drives the C shared library of re-classify, as an embedding host would:
+ *
+ *   cc -o main main.c -I DIR -L DIR -lreclassify -lpthread
+ *
+ * where DIR holds libreclassify.so and libreclassify.h. The config is read
+ * from the file named by the first argument.
+ */
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "libreclassify.h"
+
+static uintptr_t classifier;
+static char *expected;
+
+static char *read_file(const char *name) {
+    FILE *f = fopen(name, "rb");
+    if (f == NULL) {
+        perror(name);
+        exit(1);
+    }
+    static char buffer[1 << 16];
+    size_t n = fread(buffer, 1, sizeof buffer - 1, f);
+    buffer[n] = '\0';
+    fclose(f);
+    return buffer;
+}
+
+/* Classifies the same tokens as main did, from another thread. */
+static void *classify_again(void *arg) {
+    for (int i = 0; i < 100; i++) {
+        char *err = NULL;
+        char *result = reclassify_classify(classifier, "if\nx\n+\nfi\n?\n", &err);
+        if (result == NULL || strcmp(result, expected) != 0) {
+            return "differs";
+        }
+        reclassify_free_string(result);
+    }
+    return NULL;
+}
+
+int main(int argc, char **argv) {
+    char *err = NULL;
+    if (reclassify_load("variable-regexp: [\"[\"]", &err) == 0) {
+        printf("load error: %.25s\n", err);
+        reclassify_free_string(err);
+    }
+
+    err = NULL;
+    classifier = reclassify_load(read_file(argv[1]), &err);
+    if (classifier == 0) {
+        printf("load error: %s\n", err);
+        return 1;
+    }
+
+    expected = reclassify_classify(classifier, "if\nx\n+\nfi\n?\n", &err);
+    printf("%s", expected);
+
+    char *detailed = reclassify_classify_detailed(classifier, "if\nfi\n", &err);
+    printf("%s\n", detailed);
+    reclassify_free_string(detailed);
+
+    pthread_t threads[4];
+    for (int i = 0; i < 4; i++) {
+        pthread_create(&threads[i], NULL, classify_again, NULL);
+    }
+    for (int i = 0; i < 4; i++) {
+        void *failed;
+        pthread_join(threads[i], &failed);
+        printf("thread %d: %s\n", i, failed == NULL ? "same" : (char *)failed);
+    }
+    reclassify_free_string(expected);
+    reclassify_free(classifier);
+
+    err = NULL;
+    if (reclassify_classify(0, "x\n", &err) == NULL) {
+        printf("classify error: %s\n", err);
+        reclassify_free_string(err);
+    }
+    return 0;
+}
@@ -0,0 +1,25 @@
+// Drives the WebAssembly build of re-classify under Node.js, as a browser
+// would: node run.js <wasm_exec.js> <re-classify.wasm>
+const fs = require("fs");
+require(process.argv[2]);
+
+const go = new Go();
+WebAssembly.instantiate(fs.readFileSync(process.argv[3]), go.importObject).then(({ instance }) => {
+  go.run(instance);
+
+  console.log(loadConfig("variable-regexp: [\"[\"]").split(":")[0]);
+  try {
+    classify(["x"]);
+  } catch (e) {
+    console.log("error:", e.message);
+  }
+  console.log(loadConfig(fs.readFileSync("functests/simple-config.yaml", "utf8")));
+  console.log(JSON.stringify(classify(["if", "x", "+", "fi", "?"])));
+  console.log(JSON.stringify(classifyDetailed(["if", "fi"])));
+  try {
+    classify("x");
+  } catch (e) {
+    console.log("error:", e.message);
+  }
+  process.exit(0);
+});
@@ -3,6 +3,9 @@ module github.com/sfkleach/re-classify
 go 1.24.2
 
 require (
+	github.com/dlclark/regexp2 v1.12.0
 	github.com/sfkleach/regexptable v0.1.2
+	github.com/tetratelabs/wazero v1.9.0
+	golang.org/x/text v0.32.0
 	gopkg.in/yaml.v3 v3.0.1
 )
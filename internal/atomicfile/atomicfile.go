@@ -0,0 +1,66 @@
+// Package atomicfile writes files via a temporary file and a rename, so that
+// a crash part way through never leaves a truncated file behind for
+// downstream jobs to consume.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// File is an output file that only appears at its path once committed.
+type File struct {
+	tmp  *os.File
+	path string
+	done bool
+}
+
+// Create starts writing a file that will replace path when committed. The
+// temporary file is created in the same directory so that the rename is
+// atomic.
+func Create(path string) (*File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &File{tmp: tmp, path: path}, nil
+}
+
+// Write writes to the temporary file.
+func (f *File) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+// Commit flushes the temporary file to disk and renames it into place.
+func (f *File) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	err := f.tmp.Sync()
+	if cerr := f.tmp.Close(); err == nil {
+		err = cerr
+	}
+	// CreateTemp makes the file private; results are ordinary files.
+	if err == nil {
+		err = os.Chmod(f.tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.tmp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.tmp.Name())
+	}
+	return err
+}
+
+// Abort discards the temporary file. It does nothing once the file has been
+// committed, so it is safe to defer.
+func (f *File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
@@ -0,0 +1,180 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/atomicfile"
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/inputenc"
+)
+
+// OutputSuffix is appended to the name of each input file to give the name of
+// its result file.
+const OutputSuffix = ".classified"
+
+// SummaryFile is the name of the combined summary written to the output
+// directory.
+const SummaryFile = "summary.tsv"
+
+// Result summarizes the classification of a single file.
+type Result struct {
+	File   string         // The input file
+	Tokens int            // The number of tokens read
+	Counts map[string]int // The number of tokens with each classification code
+}
+
+// Runner classifies token files, each with its own form mappings.
+type Runner struct {
+	Engine   *classifier.ClassifierEngine
+	Config   *config.ClassifierConfig
+	Options  *classifier.ProcessOptions
+	Encoding string // The input encoding, see inputenc.NewReader
+}
+
+// Run classifies each file and writes its result to the same relative path
+// beneath outDir, with OutputSuffix appended. Paths are made relative to
+// base, which is normally the Base of the glob.
+func (r *Runner) Run(files []string, base, outDir string) ([]Result, error) {
+	results := make([]Result, 0, len(files))
+	for _, file := range files {
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return nil, err
+		}
+		result, err := r.runFile(file, filepath.Join(outDir, rel+OutputSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Check classifies each file in quiet mode, logging its problems without
+// writing any results, and returns the total number of problems found.
+func (r *Runner) Check(files []string) (int, error) {
+	problems := 0
+	for _, file := range files {
+		opts := *r.options()
+		opts.Quiet, opts.Source = true, file
+		checker := *r
+		checker.Options = &opts
+		_, err := checker.Classify(file, io.Discard)
+		var found *classifier.ProblemsError
+		if errors.As(err, &found) {
+			problems += found.Problems
+		} else if err != nil {
+			return 0, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return problems, nil
+}
+
+// runFile classifies a single file, writing the result to outFile.
+func (r *Runner) runFile(file, outFile string) (Result, error) {
+	if err := os.MkdirAll(filepath.Dir(outFile), 0o755); err != nil {
+		return Result{}, err
+	}
+	out, err := atomicfile.Create(outFile)
+	if err != nil {
+		return Result{}, err
+	}
+	defer out.Abort()
+	result, err := r.Classify(file, out)
+	if err != nil {
+		return Result{}, err
+	}
+	return result, out.Commit()
+}
+
+// Classify classifies a single file with fresh form mappings, writing the
+// classifications to w.
+func (r *Runner) Classify(file string, w io.Writer) (Result, error) {
+	tokens, lines, err := r.readTokens(file)
+	if err != nil {
+		return Result{}, err
+	}
+	opts := r.options()
+	if opts.Quiet {
+		// The lines locate the problems.
+		quiet := *opts
+		quiet.Lines = lines
+		opts = &quiet
+	}
+
+	// Every file gets fresh form mappings.
+	engine := r.Engine.Clone()
+	if err := engine.BuildFormStartEndMappings(tokens, r.Config); err != nil {
+		return Result{}, fmt.Errorf("error building form mappings: %w", err)
+	}
+	result := Result{File: file, Tokens: len(tokens), Counts: make(map[string]int)}
+	var prev *classifier.Classification
+	for _, token := range tokens {
+		prev = engine.ClassifyAfter(prev, token)
+		result.Counts[prev.Code]++
+	}
+	if err := engine.WriteClassifications(w, tokens, opts); err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// options returns the processing options, which default to the plain
+// protocol format.
+func (r *Runner) options() *classifier.ProcessOptions {
+	if r.Options == nil {
+		return &classifier.ProcessOptions{}
+	}
+	return r.Options
+}
+
+// readTokens reads the tokens of a file in the runner's encoding.
+func (r *Runner) readTokens(file string) ([]string, []int, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+	input, err := inputenc.NewReader(in, r.Encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	return classifier.ReadTokenLines(input, r.options().MaxTokenBytes, r.Engine.TokenPolicy())
+}
+
+// WriteSummary writes a tab-separated table with a row per file giving the
+// number of tokens of each class, followed by a row of totals.
+func WriteSummary(w io.Writer, results []Result) error {
+	header := append([]string{"file", "tokens"}, classifier.Codes...)
+	if _, err := fmt.Fprintln(w, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+	total := Result{File: "TOTAL", Counts: make(map[string]int)}
+	for _, result := range results {
+		if err := writeRow(w, result); err != nil {
+			return err
+		}
+		total.Tokens += result.Tokens
+		for code, n := range result.Counts {
+			total.Counts[code] += n
+		}
+	}
+	return writeRow(w, total)
+}
+
+// writeRow writes a single row of the summary.
+func writeRow(w io.Writer, result Result) error {
+	row := []string{result.File, strconv.Itoa(result.Tokens)}
+	for _, code := range classifier.Codes {
+		row = append(row, strconv.Itoa(result.Counts[code]))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(row, "\t"))
+	return err
+}
@@ -0,0 +1,87 @@
+// Package batch classifies many token files in one run.
+package batch
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Glob returns the regular files matching pattern, in lexical order. As well
+// as the syntax of path.Match, a "**" segment matches zero or more
+// directories, so "corpus/**/*.tokens" finds token files at any depth.
+func Glob(pattern string) ([]string, error) {
+	base, rest := split(pattern)
+	for _, segment := range rest {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	var files []string
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		if match(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error expanding glob %q: %w", pattern, err)
+	}
+	return files, nil
+}
+
+// Base returns the leading directories of pattern that contain no wildcards.
+// Output paths are made relative to it.
+func Base(pattern string) string {
+	base, _ := split(pattern)
+	return base
+}
+
+// split separates the wildcard-free directory prefix of a pattern from the
+// remaining segments.
+func split(pattern string) (string, []string) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	i := 0
+	for i < len(segments)-1 && !strings.ContainsAny(segments[i], `*?[\`) {
+		i++
+	}
+	base := strings.Join(segments[:i], "/")
+	if base == "" && i > 0 {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+	return filepath.FromSlash(base), segments[i:]
+}
+
+// match reports whether the path segments match the pattern segments.
+func match(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if match(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && match(pattern[1:], segments[1:])
+}
@@ -0,0 +1,140 @@
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/atomicfile"
+)
+
+// TokensSuffix and ExpectedSuffix name the files of a golden test: each
+// tokens file is classified and compared with the expected file of the same
+// name.
+const (
+	TokensSuffix   = ".tokens"
+	ExpectedSuffix = ".expected"
+)
+
+// maxDiffLines limits the differences reported for each file.
+const maxDiffLines = 20
+
+// Outcomes of a golden test.
+const (
+	GoldenPass    = "PASS"
+	GoldenFail    = "FAIL"
+	GoldenMissing = "MISSING"
+	GoldenUpdated = "UPDATED"
+)
+
+// GoldenResult is the outcome of comparing one tokens file with its expected
+// classifications.
+type GoldenResult struct {
+	File    string   // The tokens file
+	Outcome string   // One of the Golden outcomes
+	Diff    []string // The differences, for a failure
+}
+
+// ExpectedFile returns the name of the expected file for a tokens file.
+func ExpectedFile(tokensFile string) string {
+	return strings.TrimSuffix(tokensFile, TokensSuffix) + ExpectedSuffix
+}
+
+// Golden classifies every tokens file beneath dir and compares the result
+// with its expected file. With update, the expected files are (re)written
+// instead, and only those that changed are reported as updated.
+func (r *Runner) Golden(dir string, update bool) ([]GoldenResult, error) {
+	files, err := Glob(filepath.Join(dir, "**", "*"+TokensSuffix))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]GoldenResult, 0, len(files))
+	for _, file := range files {
+		result, err := r.golden(file, update)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// golden runs the golden test of a single tokens file.
+func (r *Runner) golden(file string, update bool) (GoldenResult, error) {
+	var got bytes.Buffer
+	if _, err := r.Classify(file, &got); err != nil {
+		return GoldenResult{}, err
+	}
+	expectedFile := ExpectedFile(file)
+	want, err := os.ReadFile(expectedFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return GoldenResult{}, err
+	}
+	missing := err != nil
+
+	switch {
+	case !missing && bytes.Equal(want, got.Bytes()):
+		return GoldenResult{File: file, Outcome: GoldenPass}, nil
+	case update:
+		out, err := atomicfile.Create(expectedFile)
+		if err != nil {
+			return GoldenResult{}, err
+		}
+		defer out.Abort()
+		if _, err := out.Write(got.Bytes()); err != nil {
+			return GoldenResult{}, err
+		}
+		return GoldenResult{File: file, Outcome: GoldenUpdated}, out.Commit()
+	case missing:
+		return GoldenResult{File: file, Outcome: GoldenMissing}, nil
+	}
+
+	tokens, _, err := r.readTokens(file)
+	if err != nil {
+		return GoldenResult{}, err
+	}
+	return GoldenResult{File: file, Outcome: GoldenFail, Diff: diffLines(tokens, lines(want), lines(got.Bytes()))}, nil
+}
+
+// diffLines describes the differences between the expected and actual
+// classifications. There is a line of output per token, so they are compared
+// line by line and each difference is labelled with its token.
+func diffLines(tokens, want, got []string) []string {
+	var diff []string
+	for i := range max(len(want), len(got)) {
+		w, g := line(want, i), line(got, i)
+		if w == g {
+			continue
+		}
+		if len(diff) == maxDiffLines {
+			diff = append(diff, "...")
+			break
+		}
+		diff = append(diff, fmt.Sprintf("line %d, token %q: expected %q, got %q", i+1, line(tokens, i), w, g))
+	}
+	if len(want) != len(got) {
+		diff = append(diff, fmt.Sprintf("expected %d lines, got %d", len(want), len(got)))
+	}
+	return diff
+}
+
+// lines splits output into lines, without a trailing empty line.
+func lines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// line returns the i'th line, or "" past the end.
+func line(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package classifier
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// Names of the configuration sections, as they appear in the YAML file.
+const (
+	SectionSurround      = "surround-regexp"
+	SectionFormPrefix    = "form-prefix-regexp"
+	SectionSimpleLabel   = "simple-label-regexp"
+	SectionCompoundLabel = "compound-label-regexp"
+	SectionVariable      = "variable-regexp"
+	SectionOperator      = "operator-regexp"
+	SectionBracketPairs  = "bracket-pairs"
+	SectionComment       = "comment-regexp"
+	SectionString        = "string-regexp"
+	SectionNumber        = "number-regexp"
+)
+
+// Codes lists every classification code in the order the lookup tries them.
+var Codes = config.ClassCodes
+
+// longNames maps each classification code to its human-readable name.
+var longNames = map[string]string{
+	"#": "comment",
+	"Q": "string",
+	"N": "number",
+	"C": "compound-label",
+	"L": "simple-label",
+	"P": "form-prefix",
+	"S": "form-start",
+	"E": "form-end",
+	"I": "intermediate",
+	"O": "operator",
+	"V": "variable",
+	"[": "open-delimiter",
+	"]": "close-delimiter",
+	"U": "unclassified",
+}
+
+// Legend returns a fresh map from each classification code to its
+// human-readable name, for use in reports and logs.
+func Legend() map[string]string {
+	return maps.Clone(longNames)
+}
+
+// LongName returns the human-readable name of a classification code, or the
+// code itself if it is not recognised.
+func LongName(code string) string {
+	if name, ok := longNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// Classification is the result of classifying a single token. As well as the
+// 1-letter code and its additional data it records which section and pattern
+// of the configuration were responsible, which is useful for explaining
+// why a token was classified the way it was.
+type Classification struct {
+	Code          string   // The 1-letter classification code e.g. "S", "O", "U"
+	Data          []string // Additional data following the code e.g. end tokens
+	Section       string   // The config section that matched, empty if unclassified
+	Pattern       string   // The pattern within that section that matched
+	CaptureGroups []string // The match groups, [0] is the whole token
+
+	Serial    int                            // The surround group of a form-start, form-end or intermediate, otherwise -1
+	EndTokens []string                       // The possible form-ends of a form-start
+	Groups    []int                          // The groups that a form-end could close, see SetReportEndGroups
+	Operator  *config.CompiledOperatorConfig // The precedences of an operator
+	Priority  int                            // The priority of the matching pattern, 0 by default
+
+	// OutputCode is the name that output-codes gives the code, empty if it
+	// is not renamed.
+	OutputCode string
+}
+
+// WrittenCode returns the code as it is written in the output, after any
+// renaming by output-codes.
+func (c *Classification) WrittenCode() string {
+	if c.OutputCode != "" {
+		return c.OutputCode
+	}
+	return c.Code
+}
+
+// String renders the classification as a line of the classification protocol.
+func (c *Classification) String() string {
+	if len(c.Data) == 0 {
+		return c.WrittenCode()
+	}
+	return c.WrittenCode() + " " + strings.Join(c.Data, " ")
+}
+
+// LongString renders the classification like String but with the long name
+// of the code in place of the letter. A code renamed by output-codes keeps
+// its new name.
+func (c *Classification) LongString() string {
+	name := c.OutputCode
+	if name == "" {
+		name = LongName(c.Code)
+	}
+	if len(c.Data) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(c.Data, " ")
+}
+
+// Detail is a classification taken apart into its fields, for library
+// consumers that would otherwise have to parse the line of the protocol back
+// apart. It has JSON field names for the hosts that receive it as JSON.
+type Detail struct {
+	Class         string        `json:"class"`                    // The code as written, after output-codes
+	Section       string        `json:"section,omitempty"`        // The config section that matched, empty if unclassified
+	Pattern       string        `json:"pattern,omitempty"`        // The pattern within that section that matched
+	CaptureGroups []string      `json:"capture_groups,omitempty"` // The match groups, [0] is the whole token
+	EndTokens     []string      `json:"end_tokens,omitempty"`     // The possible form-ends of a form-start
+	Operator      *OperatorInfo `json:"operator,omitempty"`       // The precedences of an operator, nil otherwise
+	Serial        int           `json:"serial"`                   // The surround group, otherwise -1
+}
+
+// OperatorInfo gives the precedences of an operator, 0 for the forms it
+// does not take.
+type OperatorInfo struct {
+	PrefixPrec  uint16 `json:"prefix_prec"`
+	InfixPrec   uint16 `json:"infix_prec"`
+	PostfixPrec uint16 `json:"postfix_prec"`
+}
+
+// Detail returns the fields of the classification.
+func (c *Classification) Detail() Detail {
+	d := Detail{
+		Class:         c.WrittenCode(),
+		Section:       c.Section,
+		Pattern:       c.Pattern,
+		CaptureGroups: c.CaptureGroups,
+		EndTokens:     c.EndTokens,
+		Serial:        c.Serial,
+	}
+	if c.Operator != nil {
+		d.Operator = &OperatorInfo{
+			PrefixPrec:  c.Operator.PrefixPrec,
+			InfixPrec:   c.Operator.InfixPrec,
+			PostfixPrec: c.Operator.PostfixPrec,
+		}
+	}
+	return d
+}
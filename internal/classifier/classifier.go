@@ -1,22 +1,79 @@
 package classifier
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"iter"
+	"log/slog"
+	"maps"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/hooks"
 	"github.com/sfkleach/regexptable"
 )
 
 // Pre-compiled regex for detecting non-zero substitution variables
 var nonZeroSubstRegex = regexp.MustCompile(`\$[1-9]`)
 
-// ClassifierEngine implements the token classification logic
+// ClassifierEngine implements the token classification logic. The compiled
+// config is shared and never modified; the start and end token tables are
+// built per token stream by BuildFormStartEndMappings and are private to the
+// engine. An engine must not be used by several goroutines at once, but
+// engines created with Clone can be used concurrently with their original.
 type ClassifierEngine struct {
 	config *config.CompiledClassifierConfig
+	tracer func(*Trace) // Optional, called after every classification
+
+	startTokenTable *regexptable.RegexpTable[*config.StartTokenInfo] // Maps start patterns to start token info
+	endTokenTable   *regexptable.RegexpTable[endTokenInfo]
+
+	intermediateTokenTable *regexptable.RegexpTable[endTokenInfo]
+
+	// groupPatterns maps serial numbers onto the pattern that starts the
+	// group: the surround starts followed by the operator patterns.
+	groupPatterns []string
+
+	// groupIntermediates maps the serial numbers of the surround groups
+	// onto their intermediates, before substitution.
+	groupIntermediates [][]string
+
+	// profile, if not nil, records the time taken by each classification.
+	profile *Profile
+
+	// endGroups records the groups that each form-end could close.
+	endGroups       *endGroups
+	reportEndGroups bool // Whether form-ends are followed by their groups
+
+	// incremental, if not nil, extends the form mappings as the tokens are
+	// seen, see BeginFormStartEndMappings.
+	incremental *formMappings
+
+	// diagnostics receives what is inferred while building the form
+	// mappings, see SetDiagnostics.
+	diagnostics config.Diagnostics
+
+	// hookInstances holds the engine's instances of the hook modules,
+	// created on first use.
+	hookInstances []*hooks.Instance
+}
+
+// endTokenInfo identifies the pattern that matched a form-end or
+// intermediate and the group that it belongs to, which is either a surround
+// group or a form-starting operator.
+type endTokenInfo struct {
+	Pattern      string
+	SerialNumber int
+	Section      string
 }
 
 // NewClassifierEngine creates a new classifier engine with the given configuration
@@ -26,38 +83,168 @@ func NewClassifierEngine(cfg *config.CompiledClassifierConfig) *ClassifierEngine
 	}
 }
 
+// Clone returns an engine that shares the compiled configuration but has its
+// own form mappings, so that it can be used from another goroutine. The
+// clone starts with the same mappings as the original. Mappings that are
+// being built incrementally are copied, so that the original and the clone
+// can go on extending them independently, see BeginFormStartEndMappings.
+func (ce *ClassifierEngine) Clone() *ClassifierEngine {
+	// The tables are replaced, never modified, by BuildFormStartEndMappings,
+	// so sharing the current ones is safe.
+	clone := *ce
+	clone.incremental = nil
+	clone.hookInstances = nil
+	if ce.incremental != nil {
+		// Extending the mappings modifies the tables in place.
+		if err := clone.cloneFormMappings(ce.incremental); err != nil {
+			// The same patterns have already been built once.
+			panic(err)
+		}
+	}
+	return &clone
+}
+
+// SetTracer installs a function that is called with the trace of every
+// subsequent classification. Pass nil to stop tracing.
+func (ce *ClassifierEngine) SetTracer(tracer func(*Trace)) {
+	ce.tracer = tracer
+}
+
+// SetDiagnostics installs a function that receives the diagnostics of
+// building the form mappings: the endings inferred from end patterns and the
+// patterns backfilled from form-starts. Pass nil to log them with slog, as
+// is the default.
+func (ce *ClassifierEngine) SetDiagnostics(ds config.Diagnostics) {
+	ce.diagnostics = ds
+}
+
+// TokenPolicy returns how the lines of the input become tokens, as
+// configured by token-whitespace and blank-tokens.
+func (ce *ClassifierEngine) TokenPolicy() config.TokenPolicy {
+	return ce.config.Tokens
+}
+
+// SetReportEndGroups sets whether form-ends are followed by the surround
+// groups that they could close, given the start tokens in the stream, as a
+// comma-separated list of serial numbers e.g. "E 0,3". The groups are
+// recorded in Classification.Groups either way.
+func (ce *ClassifierEngine) SetReportEndGroups(report bool) {
+	ce.reportEndGroups = report
+}
+
 // BuildFormStartEndMappings analyzes all tokens and dynamically builds the classification tables
 func (ce *ClassifierEngine) BuildFormStartEndMappings(tokens []string, cfg *config.ClassifierConfig) error {
+	return ce.BuildFormStartEndMappingsSeq(slices.Values(tokens), cfg)
+}
+
+// BuildFormStartEndMappingsSeq is like BuildFormStartEndMappings but draws
+// the tokens from a sequence, which it consumes exactly once. Only the
+// distinct form-starts and form-ends are kept, so the memory needed does not
+// grow with the length of the stream.
+func (ce *ClassifierEngine) BuildFormStartEndMappingsSeq(tokens iter.Seq[string], cfg *config.ClassifierConfig) error {
+	_, err := ce.buildFormMappings(tokens, cfg)
+	return err
+}
+
+// buildFormMappings implements BuildFormStartEndMappingsSeq, returning the
+// state of the build for PlanFormStartEndMappings.
+func (ce *ClassifierEngine) buildFormMappings(tokens iter.Seq[string], cfg *config.ClassifierConfig) (*formMappings, error) {
+	ce.incremental = nil
+	m, err := ce.beginFormMappings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for token := range tokens {
+		n++
+		if err := m.observe(token); err != nil {
+			return nil, err
+		}
+	}
+	return m, ce.finishFormMappings(m, n)
+}
+
+// formMappings is the state of the form mappings while they are built from
+// the tokens of a stream.
+type formMappings struct {
+	cfg    *config.ClassifierConfig
+	engine *ClassifierEngine
+
+	// The start of each surround group, whose endings are inferred from the
+	// tokens that match its end pattern.
+	startTokenInfoList []*config.StartTokenInfo
+	inferEndingsTable  *regexptable.RegexpTable[int]
+
+	// The tables of form-ends and intermediates, before the patterns
+	// backfilled from the tokens are added.
+	endTokenTableBuilder     *regexptable.RegexpTableBuilder[endTokenInfo]
+	intermediateTableBuilder *regexptable.RegexpTableBuilder[endTokenInfo]
+	intermediates            int
+
+	// The groups whose end patterns, or intermediates, are backfilled from
+	// their start tokens, and the patterns backfilled so far.
+	backfillEnd, backfillOperator, backfillIntermediate    map[int]bool
+	endBackfills, operatorBackfills, intermediateBackfills backfills
+
+	closers       *endGroups
+	formOperators bool // Whether any operators start forms
+}
 
-	// Build a config-based StartTokenTable that maps start patterns to StartTokenInfo.
-	configStartTableBuilder := regexptable.NewRegexpTableBuilder[*config.StartTokenInfo]()
-	startTokenInfoList := make([]*config.StartTokenInfo, len(cfg.SurroundRegexp))
+// beginFormMappings builds the tables that do not depend on the tokens and
+// prepares to observe the tokens.
+func (ce *ClassifierEngine) beginFormMappings(cfg *config.ClassifierConfig) (*formMappings, error) {
+	groupPatterns := make([]string, 0, len(cfg.SurroundRegexp)+len(cfg.OperatorRegexp))
+	for _, surroundConfig := range cfg.SurroundRegexp {
+		groupPatterns = append(groupPatterns, surroundConfig.StartPattern())
+	}
+	for _, opConfig := range cfg.OperatorRegexp {
+		groupPatterns = append(groupPatterns, opConfig.Pattern)
+	}
+	ce.groupPatterns = groupPatterns
+	ce.groupIntermediates = make([][]string, len(cfg.SurroundRegexp))
 	for i, surroundConfig := range cfg.SurroundRegexp {
-		if surroundConfig.Start != "" {
+		ce.groupIntermediates[i] = surroundConfig.Intermediates
+	}
+	m := &formMappings{cfg: cfg, engine: ce}
+
+	// Build a config-based start token table that maps start patterns to
+	// StartTokenInfo. The first matching pattern wins, so the groups are
+	// added in order of priority.
+	configStartTableBuilder := config.NewTableBuilder[*config.StartTokenInfo](ce.config.RegexpEngine)
+	m.startTokenInfoList = make([]*config.StartTokenInfo, len(cfg.SurroundRegexp))
+	for _, i := range cfg.SurroundOrder() {
+		surroundConfig := cfg.SurroundRegexp[i]
+		if starts := surroundConfig.StartPatterns(); len(starts) > 0 {
 			// Create StartTokenInfo with serial number and endings
 			startInfo := &config.StartTokenInfo{
 				SerialNumber: i, // Use the index as the serial number
+				Pattern:      surroundConfig.StartPattern(),
 				Endings:      make(map[string]bool),
 			}
 			for _, ending := range surroundConfig.Endings {
 				startInfo.Endings[ending] = true
 			}
 
-			startTokenInfoList[i] = startInfo
-			configStartTableBuilder.AddPattern(surroundConfig.Start, startInfo)
+			// Each start is added on its own so that its capture groups
+			// are numbered independently of the others.
+			m.startTokenInfoList[i] = startInfo
+			for _, start := range starts {
+				configStartTableBuilder.AddPattern(start, startInfo)
+			}
 		}
 	}
 	t, err := configStartTableBuilder.Build(true, true)
 	if err != nil {
-		return fmt.Errorf("failed to build start token table: %w", err)
+		return nil, fmt.Errorf("failed to build start token table: %w", err)
 	}
-	ce.config.StartTokenTable = t
+	ce.startTokenTable = t
+	slog.Debug("built start token table", "groups", len(cfg.SurroundRegexp))
 
 	// When Endings is not set, the startInfoTokens will be missing proper
 	// endings. So we must infer the endings from the end patterns
 	// applied to the list of tokens and backfill the startInfoTokens.
 	count := 0
-	inferEndingsTableBuilder := regexptable.NewRegexpTableBuilder[int]()
+	inferEndingsTableBuilder := config.NewTableBuilder[int](ce.config.RegexpEngine)
 	for i, surroundConfig := range cfg.SurroundRegexp {
 		if len(surroundConfig.Endings) == 0 && surroundConfig.End != "" {
 			inferEndingsTableBuilder.AddPattern(surroundConfig.End, i)
@@ -65,170 +252,995 @@ func (ce *ClassifierEngine) BuildFormStartEndMappings(tokens []string, cfg *conf
 		}
 	}
 	if count > 0 {
-		it, err := inferEndingsTableBuilder.Build(true, true)
+		m.inferEndingsTable, err = inferEndingsTableBuilder.Build(true, true)
 		if err != nil {
-			return fmt.Errorf("failed to build inferred endings table: %w", err)
-		}
-		// If there are no explicit endings, we need to find all tokens that match the end pattern
-		for _, token := range tokens {
-			if serialNumber, _, ok := it.TryLookup(token); ok {
-				startTokenInfoList[serialNumber].Endings[token] = true
-			}
+			return nil, fmt.Errorf("failed to build inferred endings table: %w", err)
 		}
 	}
 
-	// Now we create the ce.config.EndTokenTable - but a backfill obligation
+	// Now we create the ce.endTokenTable - but a backfill obligation
 	// may remain.
-	backfillEnd := make(map[int]bool, 0)
-	endTokenTableBuilder := regexptable.NewRegexpTableBuilder[bool]()
+	m.backfillEnd = make(map[int]bool, 0)
+	m.endTokenTableBuilder = config.NewTableBuilder[endTokenInfo](ce.config.RegexpEngine)
 	for i, surroundConfig := range cfg.SurroundRegexp {
 		if surroundConfig.End != "" {
-			endTokenTableBuilder.AddPattern(surroundConfig.End, true)
-		} else {
-			// If there is no End then we must infer it from the Endings
-			// pattern, if possible.
-			for _, ending := range surroundConfig.Endings {
-				// Does the pattern contain $0 or $N, N>1.
-				hasDollarZero := strings.Contains(ending, "$0")
-				hasDollarNonZero := nonZeroSubstRegex.MatchString(ending)
-				if !hasDollarZero && !hasDollarNonZero {
-					endTokenTableBuilder.AddPattern(regexp.QuoteMeta(ending), true)
-				} else if hasDollarZero && !hasDollarNonZero {
-					// Split at $0 and QuoteMeta the components then join
-					// using the Start regexp wrapped in a non-capturing group.
-					startPattern := "(?:" + surroundConfig.Start + ")"
-					parts := strings.Split(ending, "$0")
-					for i, part := range parts {
-						parts[i] = regexp.QuoteMeta(part)
+			m.endTokenTableBuilder.AddPattern(surroundConfig.End, endTokenInfo{surroundConfig.End, i, SectionSurround})
+			continue
+		}
+		// If there is no End then we must infer it from the Endings
+		// pattern, if possible.
+		if addEndPatterns(m.endTokenTableBuilder, surroundConfig.StartPattern(), surroundConfig.Endings, i, SectionSurround) {
+			m.backfillEnd[i] = true
+		}
+	}
+
+	// Operators with end tokens start forms too, so their end tokens join
+	// the same machinery.
+	m.backfillOperator = make(map[int]bool, 0)
+	for i, opConfig := range cfg.OperatorRegexp {
+		if len(opConfig.EndTokens) > 0 {
+			serial := len(cfg.SurroundRegexp) + i
+			if addEndPatterns(m.endTokenTableBuilder, opConfig.Pattern, opConfig.EndTokens, serial, SectionOperator) {
+				m.backfillOperator[serial] = true
+			}
+		}
+	}
+	if ce.config.OperatorRegexpTable == nil {
+		clear(m.backfillOperator)
+	}
+
+	// The intermediate keywords, such as else and elif, that belong to the
+	// surround groups.
+	m.backfillIntermediate = make(map[int]bool, 0)
+	m.intermediateTableBuilder = config.NewTableBuilder[endTokenInfo](ce.config.RegexpEngine)
+	for i, surroundConfig := range cfg.SurroundRegexp {
+		m.intermediates += len(surroundConfig.Intermediates)
+		if addEndPatterns(m.intermediateTableBuilder, surroundConfig.StartPattern(), surroundConfig.Intermediates, i, SectionSurround) {
+			m.backfillIntermediate[i] = true
+		}
+	}
+
+	m.closers = &endGroups{compile: ce.config.CompilePattern}
+	for _, opConfig := range cfg.OperatorRegexp {
+		m.formOperators = m.formOperators || len(opConfig.EndTokens) > 0
+	}
+	m.formOperators = m.formOperators && ce.config.OperatorRegexpTable != nil
+	return m, nil
+}
+
+// observe infers the endings and collects the backfilled patterns from a
+// token. Each start or operator token contributes its patterns once,
+// however often it occurs.
+func (m *formMappings) observe(token string) error {
+	ce, cfg := m.engine, m.cfg
+	if normalize := ce.config.NormalizeToken; normalize != nil {
+		token = normalize(token)
+	}
+	if m.inferEndingsTable != nil {
+		if serialNumber, _, ok := m.inferEndingsTable.TryLookup(token); ok {
+			if !m.startTokenInfoList[serialNumber].Endings[token] {
+				ce.diagnostics.Report(config.Diagnostic{Level: slog.LevelDebug, Message: "inferred ending from end pattern", Section: SectionSurround, Group: serialNumber, Token: token, Pattern: cfg.SurroundRegexp[serialNumber].End})
+			}
+			m.startTokenInfoList[serialNumber].Endings[token] = true
+		}
+	}
+	if len(cfg.SurroundRegexp) > 0 {
+		if info, groups, ok := ce.startTokenTable.TryLookup(token); ok {
+			// The endings, when given, say which form-ends this start
+			// expects more precisely than the end pattern does.
+			if surroundConfig := cfg.SurroundRegexp[info.SerialNumber]; len(surroundConfig.Endings) > 0 {
+				m.closers.expect(info.SerialNumber, surroundConfig.Endings, groups)
+			} else if err := m.closers.expectPattern(info.SerialNumber, surroundConfig.End); err != nil {
+				return fmt.Errorf("surround-regexp[%d]: invalid end pattern: %w", info.SerialNumber, err)
+			}
+			if m.backfillEnd[info.SerialNumber] && m.endBackfills.add(token, regexp.QuoteMeta(token), info.SerialNumber, SectionSurround) {
+				// Backfill the end pattern for this token
+				ce.diagnostics.Report(config.Diagnostic{Level: slog.LevelDebug, Message: "backfilled end pattern from start token", Section: SectionSurround, Group: info.SerialNumber, Token: token, Pattern: regexp.QuoteMeta(token)})
+			}
+			// Intermediates that refer to capture groups are instantiated
+			// from the start tokens that actually occur.
+			if m.backfillIntermediate[info.SerialNumber] {
+				for _, intermediate := range cfg.SurroundRegexp[info.SerialNumber].Intermediates {
+					if nonZeroSubstRegex.MatchString(intermediate) {
+						quoted := regexp.QuoteMeta(config.SubstitutePattern(intermediate, groups))
+						if m.intermediateBackfills.add(token, quoted, info.SerialNumber, SectionSurround) {
+							ce.diagnostics.Report(config.Diagnostic{Level: slog.LevelDebug, Message: "backfilled intermediate pattern from start token", Section: SectionSurround, Group: info.SerialNumber, Token: token, Pattern: quoted})
+						}
 					}
-					endTokenTableBuilder.AddPattern(strings.Join(parts, startPattern), true)
-				} else {
-					// We will need to backfill this pattern by applying the
-					// endings to actual tokens.
-					backfillEnd[i] = true
 				}
 			}
 		}
 	}
-
-	if len(backfillEnd) > 0 {
-		// We need to backfill the end patterns for these tokens.
-		for _, token := range tokens {
-			if info, _, ok := ce.config.StartTokenTable.TryLookup(token); ok {
-				if backfillEnd[info.SerialNumber] {
-					// Backfill the end pattern for this token
-					endTokenTableBuilder.AddPattern(regexp.QuoteMeta(token), true)
+	if m.formOperators {
+		if op, groups, ok := ce.config.OperatorRegexpTable.TryLookup(token); ok && len(op.EndTokens) > 0 {
+			m.closers.expect(op.SerialNumber, op.EndTokens, groups)
+			for _, ending := range op.EndTokens {
+				if m.backfillOperator[op.SerialNumber] && nonZeroSubstRegex.MatchString(ending) {
+					quoted := regexp.QuoteMeta(config.SubstitutePattern(ending, groups))
+					if m.operatorBackfills.add(token, quoted, op.SerialNumber, SectionOperator) {
+						ce.diagnostics.Report(config.Diagnostic{Level: slog.LevelDebug, Message: "backfilled end pattern from operator", Section: SectionOperator, Group: op.SerialNumber, Token: token, Pattern: quoted})
+					}
 				}
 			}
 		}
 	}
+	return nil
+}
 
-	// Now we can construct ce.config.EndTokenTable.
-	ce.config.EndTokenTable, err = endTokenTableBuilder.Build(true, true)
+// finishFormMappings builds the tables of form-ends and intermediates,
+// including the patterns backfilled from the n tokens observed.
+func (ce *ClassifierEngine) finishFormMappings(m *formMappings, n int) error {
+	ce.endGroups = m.closers
+
+	// Now we can construct ce.endTokenTable.
+	m.endBackfills.addTo(m.endTokenTableBuilder)
+	m.operatorBackfills.addTo(m.endTokenTableBuilder)
+	var err error
+	ce.endTokenTable, err = m.endTokenTableBuilder.Build(true, true)
 	if err != nil {
 		return fmt.Errorf("failed to build end token table: %w", err)
 	}
+	slog.Debug("built end token table", "tokens", n)
 
+	if m.intermediates == 0 {
+		ce.intermediateTokenTable = nil
+		return nil
+	}
+	m.intermediateBackfills.addTo(m.intermediateTableBuilder)
+	ce.intermediateTokenTable, err = m.intermediateTableBuilder.Build(true, true)
+	if err != nil {
+		return fmt.Errorf("failed to build intermediate token table: %w", err)
+	}
+	slog.Debug("built intermediate token table", "intermediates", m.intermediates)
 	return nil
 }
 
+// backfills collects the distinct patterns backfilled from the tokens, in
+// order of first occurrence.
+type backfills struct {
+	seen   map[endTokenInfo]bool
+	infos  []endTokenInfo
+	tokens []string // The token that each pattern was first backfilled from
+
+	// table, if not nil, is a table that is already built, to which the
+	// patterns are added as they are found, see BeginFormStartEndMappings.
+	table *regexptable.RegexpTable[endTokenInfo]
+}
+
+// add records a pattern backfilled from a token, reporting whether it is
+// new.
+func (b *backfills) add(token, pattern string, serial int, section string) bool {
+	info := endTokenInfo{pattern, serial, section}
+	if b.seen[info] {
+		return false
+	}
+	if b.seen == nil {
+		b.seen = make(map[endTokenInfo]bool)
+	}
+	b.seen[info] = true
+	b.infos = append(b.infos, info)
+	b.tokens = append(b.tokens, token)
+	if b.table != nil {
+		// The table recompiles on its next lookup.
+		_ = b.table.AddPattern(pattern, info)
+	}
+	return true
+}
+
+// clone copies the patterns backfilled so far, without the table.
+func (b *backfills) clone() backfills {
+	return backfills{seen: maps.Clone(b.seen), infos: slices.Clone(b.infos), tokens: slices.Clone(b.tokens)}
+}
+
+// addTo adds the backfilled patterns to a table builder.
+func (b *backfills) addTo(builder *regexptable.RegexpTableBuilder[endTokenInfo]) {
+	for _, info := range b.infos {
+		builder.AddPattern(info.Pattern, info)
+	}
+}
+
+// addEndPatterns adds the patterns synthesized from the endings (or
+// intermediates) of a form-starting group to the builder. It reports whether
+// some of them refer to capture groups other than $0, in which case they must
+// be backfilled from the actual tokens.
+func addEndPatterns(builder *regexptable.RegexpTableBuilder[endTokenInfo], start string, endings []string, serial int, section string) bool {
+	backfill := false
+	for _, ending := range endings {
+		pattern, ok := endPattern(start, ending)
+		switch {
+		case !ok:
+			// We will need to backfill this pattern by applying the
+			// endings to actual tokens.
+			slog.Debug("ending needs backfill from start tokens", "group", serial, "ending", ending)
+			backfill = true
+			continue
+		case strings.Contains(ending, "$0"):
+			slog.Debug("synthesized end pattern from $0 ending", "group", serial, "ending", ending, "pattern", pattern)
+		default:
+			slog.Debug("synthesized end pattern from constant ending", "group", serial, "ending", ending, "pattern", pattern)
+		}
+		builder.AddPattern(pattern, endTokenInfo{pattern, serial, section})
+	}
+	return backfill
+}
+
+// endPattern synthesizes the pattern that matches an ending of a group with
+// the given start pattern. It reports false if the ending refers to capture
+// groups other than $0, when it must be backfilled from the start tokens.
+func endPattern(start, ending string) (string, bool) {
+	if nonZeroSubstRegex.MatchString(ending) {
+		return "", false
+	}
+	// Split at $0 and QuoteMeta the components then join using the Start
+	// regexp wrapped in a non-capturing group.
+	parts := strings.Split(ending, "$0")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, "(?:"+start+")"), true
+}
+
 // ClassifyToken classifies a single token and returns the classification string
 func (ce *ClassifierEngine) ClassifyToken(token string) string {
-	// Check compound label first (highest priority)
-	if ce.config.CompoundLabelRegexpTable != nil {
-		_, _, ok := ce.config.CompoundLabelRegexpTable.TryLookup(token)
-		if ok {
-			return "C"
-		}
+	return ce.Classify(token).String()
+}
+
+// ClassifyDetailed classifies a single token and returns the fields of its
+// classification, rather than the line of the protocol that ClassifyToken
+// returns.
+func (ce *ClassifierEngine) ClassifyDetailed(token string) Detail {
+	return ce.Classify(token).Detail()
+}
+
+// Classify classifies a single token and returns the classification together
+// with the section and pattern that were responsible for it.
+func (ce *ClassifierEngine) Classify(token string) *Classification {
+	if ce.profile != nil {
+		start := time.Now()
+		c := ce.traceClassify(token)
+		ce.profile.record(c, time.Since(start))
+		return c
 	}
+	return ce.traceClassify(token)
+}
 
-	// Check simple label
-	if ce.config.SimpleLabelRegexpTable != nil {
-		_, _, ok := ce.config.SimpleLabelRegexpTable.TryLookup(token)
-		if ok {
-			return "L"
+// traceClassify implements Classify, passing the trace to the tracer if
+// there is one.
+func (ce *ClassifierEngine) traceClassify(token string) *Classification {
+	if ce.tracer == nil {
+		return ce.classify(token, nil)
+	}
+	trace := &Trace{Token: token}
+	c := ce.classify(token, trace)
+	ce.tracer(trace)
+	return c
+}
+
+// ClassifyWithTrace classifies a single token, recording which tables were
+// consulted along the way.
+func (ce *ClassifierEngine) ClassifyWithTrace(token string) (*Classification, *Trace) {
+	trace := &Trace{Token: token}
+	return ce.classify(token, trace), trace
+}
+
+// classify implements Classify, renaming the code as output-codes directs.
+// The trace may be nil.
+func (ce *ClassifierEngine) classify(token string, trace *Trace) *Classification {
+	c := ce.lookup(token, trace)
+	if codes := ce.config.OutputCodes; codes != nil {
+		c.OutputCode = codes[c.Code]
+	}
+	return c
+}
+
+// lookup finds the classification of a token.
+func (ce *ClassifierEngine) lookup(token string, trace *Trace) *Classification {
+	// The protocol has no empty tokens. Excluding them also keeps lookups
+	// read-only, because regexptable lazily caches state when disambiguating
+	// patterns that match the empty string, which would race when the
+	// compiled config is shared.
+	if token == "" {
+		return &Classification{Code: "U", Serial: -1}
+	}
+	if normalize := ce.config.NormalizeToken; normalize != nil {
+		token = normalize(token)
+	}
+	if ce.config.Hooks != nil {
+		return ce.lookupHooked(token, trace)
+	}
+	return ce.lookupTables(token, trace)
+}
+
+// lookupTables finds the classification of a normalized token in the regex
+// tables.
+func (ce *ClassifierEngine) lookupTables(token string, trace *Trace) *Classification {
+	// Without priorities the first section to match wins, so there is no
+	// need to consult the rest.
+	if ce.config.MergedTable != nil && trace == nil {
+		return ce.classifyMerged(token)
+	}
+	if ce.config.Priorities == nil {
+		for c := range ce.matches(token, trace) {
+			return c
 		}
+		// Otherwise, it's unclassified per the specification
+		return &Classification{Code: "U", Serial: -1}
 	}
 
-	// Check form prefix
-	if ce.config.FormPrefixRegexpTable != nil {
-		_, _, ok := ce.config.FormPrefixRegexpTable.TryLookup(token)
-		if ok {
-			return "P"
+	// The highest priority wins, ties going to the earlier section.
+	var best *Classification
+	for c := range ce.matches(token, trace) {
+		c.Priority = ce.priority(c)
+		if best == nil || c.Priority > best.Priority {
+			best = c
 		}
 	}
+	if best == nil {
+		return &Classification{Code: "U", Serial: -1}
+	}
+	return best
+}
 
-	// Check form start using StartTokenTable BEFORE checking end tokens
-	if ce.config.StartTokenTable != nil {
-		startInfo, captureGroups, ok := ce.config.StartTokenTable.TryLookup(token)
-		if ok {
-			// Generate the possible end tokens for display
-			endTokens := make([]string, 0, len(startInfo.Endings))
-			for endPattern := range startInfo.Endings {
-				endToken := config.SubstitutePattern(endPattern, captureGroups)
-				endTokens = append(endTokens, endToken)
-			}
-			if len(endTokens) > 0 {
-				sort.Strings(endTokens) // Only required for consistent testable output.
-				return "S " + strings.Join(endTokens, " ")
+// Matches returns the classifications of a token by every section that
+// matches it, in the order that the sections are consulted. The first is the
+// classification unless priorities say otherwise. More than one is a
+// conflict between sections.
+func (ce *ClassifierEngine) Matches(token string) []*Classification {
+	if token == "" {
+		return nil
+	}
+	if normalize := ce.config.NormalizeToken; normalize != nil {
+		token = normalize(token)
+	}
+	var all []*Classification
+	for c := range ce.matches(token, nil) {
+		c.Priority = ce.priority(c)
+		all = append(all, c)
+	}
+	return all
+}
+
+// matches yields the classification of a normalized token by each section
+// that matches it, in order of the sections.
+func (ce *ClassifierEngine) matches(token string, trace *Trace) iter.Seq[*Classification] {
+	return func(yield func(*Classification) bool) {
+		// Comments and string literals come first, since their text could
+		// match anything, and then numeric literals before any
+		// identifier-like patterns.
+		lookups := []func(string, *Trace) *Classification{
+			ce.lookupComment,
+			ce.lookupString,
+			ce.lookupNumber,
+			ce.lookupCompoundLabel,
+			ce.lookupSimpleLabel,
+			ce.lookupFormPrefix,
+			// Form starts are checked before form ends.
+			ce.lookupFormStart,
+			ce.lookupFormEnd,
+			ce.lookupIntermediate,
+			ce.lookupOperator,
+			// A token is a variable only if nothing else claims it.
+			ce.lookupVariable,
+			ce.lookupOpenBracket,
+			ce.lookupCloseBracket,
+		}
+		for _, lookup := range lookups {
+			if c := lookup(token, trace); c != nil && !yield(c) {
+				return
 			}
-			return "S"
 		}
 	}
+}
 
-	// Check if this token is an end token using EndTokenTable
-	if ce.config.EndTokenTable != nil {
-		serialNumber, _, ok := ce.config.EndTokenTable.TryLookup(token)
-		if ok {
-			// For now, just return "E" - later we can use the serialNumber for more sophisticated matching
-			_ = serialNumber // Acknowledge we have the serial number for future use
-			return "E"
+// classifyMerged classifies a token without priorities, except patterns or
+// tracing. The sections with fixed patterns are looked up together in the
+// merged table; the form tables, which come between the form prefixes and
+// the operators, are only consulted if the merged match is not earlier.
+func (ce *ClassifierEngine) classifyMerged(token string) *Classification {
+	entry, groups, ok := ce.config.MergedTable.TryLookup(token)
+	if ok && entry.Section != SectionOperator && entry.Section != SectionVariable {
+		return mergedClassification(entry, groups)
+	}
+	for _, lookup := range []func(string, *Trace) *Classification{ce.lookupFormStart, ce.lookupFormEnd, ce.lookupIntermediate} {
+		if c := lookup(token, nil); c != nil {
+			return c
 		}
 	}
+	if ok {
+		return mergedClassification(entry, groups)
+	}
+	if c := ce.lookupOpenBracket(token, nil); c != nil {
+		return c
+	}
+	if c := ce.lookupCloseBracket(token, nil); c != nil {
+		return c
+	}
+	return &Classification{Code: "U", Serial: -1}
+}
+
+// mergedClassification converts a match in the merged table into a
+// classification.
+func mergedClassification(entry config.MergedEntry, groups []string) *Classification {
+	if entry.Operator != nil {
+		return operatorClassification(*entry.Operator, groups)
+	}
+	return &Classification{Code: sectionCodes[entry.Section], Section: entry.Section, Pattern: entry.Pattern, CaptureGroups: groups, Serial: -1}
+}
+
+// sectionCodes maps the sections that are plain lists of patterns onto their
+// classification codes.
+var sectionCodes = map[string]string{
+	SectionComment:       "#",
+	SectionString:        "Q",
+	SectionNumber:        "N",
+	SectionCompoundLabel: "C",
+	SectionSimpleLabel:   "L",
+	SectionFormPrefix:    "P",
+	SectionVariable:      "V",
+}
 
-	// Check operator using OperatorRegexpTable
-	if ce.config.OperatorRegexpTable != nil {
-		operatorTable := ce.config.OperatorRegexpTable
-		operatorConfig, _, ok := operatorTable.TryLookup(token)
-		if ok {
-			return fmt.Sprintf("O %d %d %d", operatorConfig.PrefixPrec, operatorConfig.InfixPrec, operatorConfig.PostfixPrec)
+// lookupPatterns consults the words and then the table of a section that is
+// a plain list of patterns, returning nil if the token does not match or is
+// excepted.
+func (ce *ClassifierEngine) lookupPatterns(table *regexptable.RegexpTable[string], tableName, section, token string, trace *Trace) *Classification {
+	if words := ce.config.Words[section]; words != nil {
+		hit := words.Words[token]
+		trace.record(tableName+"-words", hit, words.File, []string{token})
+		if hit && !ce.excepted(section, token, trace) {
+			return &Classification{Code: sectionCodes[section], Section: section, Pattern: words.File, CaptureGroups: []string{token}, Serial: -1}
 		}
 	}
+	if table == nil {
+		return nil
+	}
+	pattern, groups, ok := table.TryLookup(token)
+	trace.record(tableName, ok, pattern, groups)
+	if !ok || ce.excepted(section, token, trace) {
+		return nil
+	}
+	return &Classification{Code: sectionCodes[section], Section: section, Pattern: pattern, CaptureGroups: groups, Serial: -1}
+}
 
-	// Default to variable only if VariableRegexpTable exists and the token matches it.
-	if ce.config.VariableRegexpTable != nil {
-		_, _, ok := ce.config.VariableRegexpTable.TryLookup(token)
-		if ok {
-			return "V"
+func (ce *ClassifierEngine) lookupComment(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.CommentRegexpTable, TableComment, SectionComment, token, trace)
+}
+
+func (ce *ClassifierEngine) lookupString(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.StringRegexpTable, TableString, SectionString, token, trace)
+}
+
+func (ce *ClassifierEngine) lookupNumber(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.NumberRegexpTable, TableNumber, SectionNumber, token, trace)
+}
+
+func (ce *ClassifierEngine) lookupCompoundLabel(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.CompoundLabelRegexpTable, TableCompoundLabel, SectionCompoundLabel, token, trace)
+}
+
+func (ce *ClassifierEngine) lookupSimpleLabel(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.SimpleLabelRegexpTable, TableSimpleLabel, SectionSimpleLabel, token, trace)
+}
+
+func (ce *ClassifierEngine) lookupFormPrefix(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.FormPrefixRegexpTable, TableFormPrefix, SectionFormPrefix, token, trace)
+}
+
+func (ce *ClassifierEngine) lookupVariable(token string, trace *Trace) *Classification {
+	return ce.lookupPatterns(ce.config.VariableRegexpTable, TableVariable, SectionVariable, token, trace)
+}
+
+// lookupFormStart consults the start token table built from the input.
+func (ce *ClassifierEngine) lookupFormStart(token string, trace *Trace) *Classification {
+	if ce.startTokenTable == nil {
+		return nil
+	}
+	startInfo, captureGroups, ok := ce.startTokenTable.TryLookup(token)
+	if ok {
+		trace.record(TableFormStart, ok, startInfo.Pattern, captureGroups)
+	} else {
+		trace.record(TableFormStart, ok, "", nil)
+	}
+	if !ok || ce.excepted(SectionSurround, token, trace) {
+		return nil
+	}
+	// Generate the possible end tokens for display
+	endTokens := make([]string, 0, len(startInfo.Endings))
+	for endPattern := range startInfo.Endings {
+		endToken := config.SubstitutePattern(endPattern, captureGroups)
+		endTokens = append(endTokens, endToken)
+	}
+	sort.Strings(endTokens) // Only required for consistent testable output.
+	return &Classification{Code: "S", Data: endTokens, Section: SectionSurround, Pattern: startInfo.Pattern, CaptureGroups: captureGroups, Serial: startInfo.SerialNumber, EndTokens: endTokens}
+}
+
+// lookupFormEnd consults the end token table built from the input.
+func (ce *ClassifierEngine) lookupFormEnd(token string, trace *Trace) *Classification {
+	if ce.endTokenTable == nil {
+		return nil
+	}
+	endInfo, groups, ok := ce.endTokenTable.TryLookup(token)
+	trace.record(TableFormEnd, ok, endInfo.Pattern, groups)
+	if !ok || ce.excepted(endInfo.Section, token, trace) {
+		return nil
+	}
+	c := &Classification{Code: "E", Section: endInfo.Section, Pattern: endInfo.Pattern, CaptureGroups: groups, Serial: endInfo.SerialNumber}
+	// A form-end whose start is not in the stream is attributed to the
+	// group whose pattern it matched.
+	if c.Groups = ce.endGroups.closes(token); len(c.Groups) == 0 {
+		c.Groups = []int{endInfo.SerialNumber}
+	}
+	if ce.reportEndGroups {
+		c.Data = []string{formatGroups(c.Groups)}
+	}
+	return c
+}
+
+// lookupIntermediate consults the table of intermediate keywords of forms.
+func (ce *ClassifierEngine) lookupIntermediate(token string, trace *Trace) *Classification {
+	if ce.intermediateTokenTable == nil {
+		return nil
+	}
+	info, groups, ok := ce.intermediateTokenTable.TryLookup(token)
+	trace.record(TableIntermediate, ok, info.Pattern, groups)
+	if !ok || ce.excepted(info.Section, token, trace) {
+		return nil
+	}
+	serial := strconv.Itoa(info.SerialNumber)
+	return &Classification{Code: "I", Data: []string{serial}, Section: info.Section, Pattern: info.Pattern, CaptureGroups: groups, Serial: info.SerialNumber}
+}
+
+// lookupOperator consults the operator table.
+func (ce *ClassifierEngine) lookupOperator(token string, trace *Trace) *Classification {
+	if ce.config.OperatorRegexpTable == nil {
+		return nil
+	}
+	operatorConfig, groups, ok := ce.config.OperatorRegexpTable.TryLookup(token)
+	trace.record(TableOperator, ok, operatorConfig.Pattern, groups)
+	if !ok || ce.excepted(SectionOperator, token, trace) {
+		return nil
+	}
+	return operatorClassification(operatorConfig, groups)
+}
+
+// operatorClassification classifies a token that matched an operator
+// pattern.
+func operatorClassification(operatorConfig config.CompiledOperatorConfig, groups []string) *Classification {
+	data := []string{
+		strconv.Itoa(int(operatorConfig.PrefixPrec)),
+		strconv.Itoa(int(operatorConfig.InfixPrec)),
+		strconv.Itoa(int(operatorConfig.PostfixPrec)),
+	}
+	c := &Classification{Code: "O", Section: SectionOperator, Pattern: operatorConfig.Pattern, CaptureGroups: groups, Serial: -1, Operator: &operatorConfig}
+	if len(operatorConfig.EndTokens) > 0 {
+		// A form-starting operator is followed by its end tokens.
+		for _, ending := range operatorConfig.EndTokens {
+			c.EndTokens = append(c.EndTokens, config.SubstitutePattern(ending, groups))
 		}
+		sort.Strings(c.EndTokens)
+		data = append(data, c.EndTokens...)
+		c.Serial = operatorConfig.SerialNumber
+	}
+	c.Data = data
+	return c
+}
+
+// lookupOpenBracket consults the open brackets of the bracket pairs.
+func (ce *ClassifierEngine) lookupOpenBracket(token string, trace *Trace) *Classification {
+	if ce.config.OpenBracketTable == nil {
+		return nil
+	}
+	bracketConfig := ce.config.OpenBracketTable[token]
+	trace.record(TableOpenBracket, bracketConfig != nil, token, []string{token})
+	if bracketConfig == nil {
+		return nil
+	}
+	data := []string{strconv.Itoa(bracketConfig.GetFlag()), bracketConfig.Close}
+	return &Classification{Code: "[", Data: data, Section: SectionBracketPairs, Pattern: bracketConfig.Open, CaptureGroups: []string{token}, Serial: -1}
+}
+
+// lookupCloseBracket consults the close brackets of the bracket pairs.
+func (ce *ClassifierEngine) lookupCloseBracket(token string, trace *Trace) *Classification {
+	if ce.config.CloseBracketSetAsMap == nil {
+		return nil
+	}
+	ok := ce.config.CloseBracketSetAsMap[token]
+	trace.record(TableCloseBracket, ok, token, []string{token})
+	if !ok {
+		return nil
+	}
+	return &Classification{Code: "]", Section: SectionBracketPairs, Pattern: token, CaptureGroups: []string{token}, Serial: -1}
+}
+
+// priority returns the priority of the pattern behind a classification. A
+// form-start, form-end or intermediate takes the priority of its group.
+func (ce *ClassifierEngine) priority(c *Classification) int {
+	pattern := c.Pattern
+	if c.Serial >= 0 && c.Serial < len(ce.groupPatterns) {
+		pattern = ce.groupPatterns[c.Serial]
+	}
+	return ce.config.Priorities[c.Section][pattern]
+}
+
+// excepted reports whether one of the except patterns of a section vetoes
+// a match in that section, in which case the lookup carries on as if the
+// section had not matched.
+func (ce *ClassifierEngine) excepted(section, token string, trace *Trace) bool {
+	table := ce.config.ExceptTables[section]
+	if table == nil {
+		return false
+	}
+	pattern, _, ok := table.TryLookup(token)
+	if ok {
+		trace.except(pattern)
+	}
+	return ok
+}
+
+// ProcessOptions controls how Process reads its input and writes its output.
+type ProcessOptions struct {
+	Echo          io.Writer    // If not nil, classifications are also written here
+	Unbuffered    bool         // Flush the output after every classification
+	MaxTokenBytes int          // Longest acceptable input line, 0 for DefaultMaxTokenBytes
+	Filter        *ClassFilter // If not nil, only matching classifications are written
+	ShowTokens    bool         // Prefix each classification with its token and a tab
+	Unique        bool         // Write each distinct token once, implies ShowTokens
+	Count         bool         // With Unique, prefix each line with the token's occurrence count
+	LongNames     bool         // Write the long names of the codes, see Legend
+	Stateful      bool         // Attribute form-ends and intermediates to the open forms, see Nesting
+	Quiet         bool         // Write nothing, only log the problems, see ProblemsError
+	Source        string       // If not empty, names the input in the logged problems e.g. a file
+	Lines         []int        // If not nil, the input line of each token, which locates the logged problems
+
+	// Encode, if not nil, writes each classification in place of the line of
+	// the protocol, e.g. in a binary format. The options that shape the line
+	// do not apply to it, but Echo still gets the line. The position of the
+	// token in the input counts from 1.
+	Encode func(w io.Writer, position int, token string, c *Classification) error
+
+	// Header, if not empty, is written before the classifications, e.g. to
+	// name the columns of a table.
+	Header string
+}
+
+// ProblemsError is returned by Process in quiet mode when the input has
+// problems: unclassified tokens, or with Stateful, impossible nesting. Each
+// problem has already been logged as a warning.
+type ProblemsError struct {
+	Source   string // The Source of the options
+	Problems int
+}
+
+func (e *ProblemsError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("found %d problems", e.Problems)
+	}
+	return fmt.Sprintf("found %d problems in %s", e.Problems, e.Source)
+}
+
+// DefaultMaxTokenBytes is the default limit on the length of an input line.
+const DefaultMaxTokenBytes = bufio.MaxScanTokenSize
+
+// TokenTooLongError reports an input line that exceeds the token size limit.
+type TokenTooLongError struct {
+	Line  int // 1-based line number
+	Limit int // The limit in bytes
+}
+
+func (e *TokenTooLongError) Error() string {
+	return fmt.Sprintf("line %d is longer than the maximum token size of %d bytes", e.Line, e.Limit)
+}
+
+// InvalidUTF8Error reports an input line that is not valid UTF-8, when the
+// token policy rejects such lines.
+type InvalidUTF8Error struct {
+	Line int // 1-based line number
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("line %d is not valid UTF-8", e.Line)
+}
+
+// ReadTokens reads tokens from r, one per line, where a line ends with LF,
+// CRLF or a lone CR so that files from any platform give the same tokens.
+// Surrounding whitespace is trimmed and blank lines are skipped. Lines longer
+// than maxTokenBytes are rejected with a *TokenTooLongError; 0 means
+// DefaultMaxTokenBytes.
+func ReadTokens(r io.Reader, maxTokenBytes int) ([]string, error) {
+	return ReadTokensWith(r, maxTokenBytes, config.TokenPolicy{})
+}
+
+// ReadTokensWith is like ReadTokens but turns lines into tokens following
+// the policy, which may reject a line that is not valid UTF-8 with an
+// *InvalidUTF8Error.
+func ReadTokensWith(r io.Reader, maxTokenBytes int, policy config.TokenPolicy) ([]string, error) {
+	var tokens []string
+	scanner := NewTokenScannerWith(r, maxTokenBytes, policy)
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Token())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// ReadTokenLines is like ReadTokensWith but also returns the input line of
+// each token, counting from 1, which differ when blank lines are skipped.
+func ReadTokenLines(r io.Reader, maxTokenBytes int, policy config.TokenPolicy) ([]string, []int, error) {
+	var tokens []string
+	var lines []int
+	scanner := NewTokenScannerWith(r, maxTokenBytes, policy)
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Token())
+		lines = append(lines, scanner.Line())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
 	}
+	return tokens, lines, nil
+}
+
+// TokenScanner reads tokens one at a time, following the same rules as
+// ReadTokens, for streams too large to hold in memory.
+type TokenScanner struct {
+	scanner       *bufio.Scanner
+	maxTokenBytes int
+	policy        config.TokenPolicy
+	line          int
+	token         string
+	err           error
+}
+
+// NewTokenScanner returns a scanner for the tokens of r.
+func NewTokenScanner(r io.Reader, maxTokenBytes int) *TokenScanner {
+	return NewTokenScannerWith(r, maxTokenBytes, config.TokenPolicy{})
+}
 
-	if ce.config.OpenBracketTable != nil {
-		bracketConfig := ce.config.OpenBracketTable[token]
-		if bracketConfig != nil {
-			var sb strings.Builder
-			sb.WriteString(bracketConfig.GetCode())
-			sb.WriteString(" ")
-			sb.WriteString(bracketConfig.Close)
-			return sb.String()
+// NewTokenScannerWith returns a scanner for the tokens of r that turns lines
+// into tokens following the policy.
+func NewTokenScannerWith(r io.Reader, maxTokenBytes int, policy config.TokenPolicy) *TokenScanner {
+	if maxTokenBytes <= 0 {
+		maxTokenBytes = DefaultMaxTokenBytes
+	}
+	scanner := bufio.NewScanner(r)
+	// Leave room for a CRLF line ending so the limit applies to the token.
+	scanner.Buffer(make([]byte, 0, min(maxTokenBytes+2, 64*1024)), maxTokenBytes+2)
+	scanner.Split(scanLines)
+	return &TokenScanner{scanner: scanner, maxTokenBytes: maxTokenBytes, policy: policy}
+}
+
+// scanLines is like bufio.ScanLines but a line may also end with a lone
+// carriage return, as in files from classic Mac OS.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
 		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// Wait to see whether the carriage return is followed by a line feed.
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
 	}
+	return 0, nil, nil
+}
 
-	if ce.config.CloseBracketSetAsMap != nil && ce.config.CloseBracketSetAsMap[token] {
-		return "]"
+// Scan advances to the next token, returning false at the end of the input
+// or on an error.
+func (ts *TokenScanner) Scan() bool {
+	if ts.err != nil {
+		return false
 	}
+	for ts.scanner.Scan() {
+		ts.line++
+		text := ts.scanner.Text()
+		if len(text) > ts.maxTokenBytes {
+			ts.err = &TokenTooLongError{Line: ts.line, Limit: ts.maxTokenBytes}
+			return false
+		}
+		if ts.policy.InvalidUTF8 != "" && !utf8.ValidString(text) {
+			if ts.policy.InvalidUTF8 == config.InvalidUTF8Error {
+				ts.err = &InvalidUTF8Error{Line: ts.line}
+				return false
+			}
+			slog.Warn("replaced invalid UTF-8", "line", ts.line)
+			text = strings.ToValidUTF8(text, "\uFFFD")
+		}
+		ts.token = text
+		if !ts.policy.PreserveWhitespace {
+			ts.token = strings.TrimSpace(text)
+		}
+		// A line of whitespace is blank even when whitespace is preserved.
+		if ts.policy.KeepBlank || strings.TrimSpace(ts.token) != "" {
+			return true
+		}
+	}
+	if err := ts.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			ts.err = &TokenTooLongError{Line: ts.line + 1, Limit: ts.maxTokenBytes}
+		} else {
+			ts.err = fmt.Errorf("error reading tokens: %w", err)
+		}
+	}
+	return false
+}
+
+// Token returns the most recent token.
+func (ts *TokenScanner) Token() string {
+	return ts.token
+}
+
+// Line returns the input line of the most recent token, counting from 1.
+func (ts *TokenScanner) Line() int {
+	return ts.line
+}
 
-	// Otherwise, it's unclassified per the specification
-	return "U"
+// Err returns the first error encountered, if any.
+func (ts *TokenScanner) Err() error {
+	return ts.err
 }
 
-// ProcessTokens processes all tokens and outputs classifications
-func (ce *ClassifierEngine) ProcessTokens(tokens []string, echoToStderr bool) {
+// UniqueTokens returns the distinct tokens in order of first occurrence,
+// together with the number of times each one occurs.
+func UniqueTokens(tokens []string) ([]string, map[string]int) {
+	counts := make(map[string]int)
+	var unique []string
 	for _, token := range tokens {
-		classification := ce.ClassifyToken(token)
-		fmt.Println(classification)
-		if echoToStderr {
-			fmt.Fprintln(os.Stderr, classification)
+		if counts[token] == 0 {
+			unique = append(unique, token)
 		}
+		counts[token]++
 	}
+	return unique, counts
+}
+
+// Process reads all the tokens from r, builds the form mappings from them and
+// writes their classifications to w, one per line.
+func (ce *ClassifierEngine) Process(r io.Reader, w io.Writer, cfg *config.ClassifierConfig, opts *ProcessOptions) error {
+	if opts == nil {
+		opts = &ProcessOptions{}
+	}
+	var tokens []string
+	var err error
+	if opts.Quiet {
+		// The lines locate the problems.
+		quiet := *opts
+		tokens, quiet.Lines, err = ReadTokenLines(r, opts.MaxTokenBytes, ce.TokenPolicy())
+		opts = &quiet
+	} else {
+		tokens, err = ReadTokensWith(r, opts.MaxTokenBytes, ce.TokenPolicy())
+	}
+	if err != nil {
+		return err
+	}
+	slog.Debug("read tokens", "count", len(tokens))
+
+	if err := ce.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		return fmt.Errorf("error building form mappings: %w", err)
+	}
+
+	return ce.WriteClassifications(w, tokens, opts)
+}
+
+// WriteClassifications writes the classification of each token to w, one per
+// line. The form mappings must already have been built.
+func (ce *ClassifierEngine) WriteClassifications(w io.Writer, tokens []string, opts *ProcessOptions) error {
+	if opts == nil {
+		opts = &ProcessOptions{}
+	}
+	var counts map[string]int
+	if opts.Unique {
+		tokens, counts = UniqueTokens(tokens)
+	}
+	var inputLine func(position int) int
+	if opts.Lines != nil && !opts.Unique {
+		inputLine = func(position int) int { return opts.Lines[position-1] }
+	}
+	return ce.writeClassifications(w, slices.Values(tokens), counts, opts, inputLine)
+}
+
+// writeClassifications implements WriteClassifications. The counts are only
+// needed for opts.Unique, when the tokens are already distinct. inputLine, if
+// not nil, gives the input line of the token at a position.
+func (ce *ClassifierEngine) writeClassifications(w io.Writer, tokens iter.Seq[string], counts map[string]int, opts *ProcessOptions, inputLine func(position int) int) error {
+	bw := bufio.NewWriter(w)
+	logger := slog.Default()
+	if opts.Source != "" {
+		logger = slog.With("file", opts.Source)
+	}
+	var prev *Classification
+	var nesting *Nesting
+	if opts.Stateful && !opts.Unique {
+		nesting = ce.NewNesting()
+		if opts.Source != "" {
+			nesting.SetSource(opts.Source)
+		}
+	}
+	if _, err := bw.WriteString(opts.Header); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	position, unclassified := 0, 0
+	for token := range tokens {
+		position++
+		var classification *Classification
+		if opts.Unique {
+			// Distinct tokens have no context.
+			classification = ce.Classify(token)
+		} else {
+			classification = ce.ClassifyAfter(prev, token)
+			prev = classification
+		}
+		if nesting != nil {
+			classification = nesting.Resolve(token, classification)
+		}
+		if opts.Quiet {
+			if classification.Code == "U" {
+				unclassified++
+				if inputLine != nil {
+					logger.Warn("unclassified token", "token", token, "line", inputLine(position))
+				} else {
+					logger.Warn("unclassified token", "token", token, "position", position)
+				}
+			}
+			continue
+		}
+		if !opts.Filter.Allows(classification.Code) {
+			continue
+		}
+		line := classification.String()
+		if opts.LongNames {
+			line = classification.LongString()
+		}
+		if opts.ShowTokens || opts.Unique {
+			line = token + "\t" + line
+		}
+		if opts.Unique && opts.Count {
+			line = strconv.Itoa(counts[token]) + "\t" + line
+		}
+		var err error
+		if opts.Encode != nil {
+			err = opts.Encode(bw, position, token, classification)
+		} else {
+			_, err = fmt.Fprintln(bw, line)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		if opts.Unbuffered {
+			if err := bw.Flush(); err != nil {
+				return fmt.Errorf("error writing output: %w", err)
+			}
+		}
+		if opts.Echo != nil {
+			fmt.Fprintln(opts.Echo, line)
+		}
+	}
+	if nesting != nil {
+		nesting.Finish()
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	if opts.Quiet {
+		problems := unclassified
+		if nesting != nil {
+			problems += nesting.Warnings()
+		}
+		if problems > 0 {
+			return &ProblemsError{Source: opts.Source, Problems: problems}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,151 @@
+package classifier
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// cloneTestConfig has an intermediate that is backfilled from the start
+// tokens, so that extending the mappings adds patterns to the tables.
+const cloneTestConfig = `
+surround-regexp:
+  - start: "if(\\w*)"
+    end: "fi\\w*"
+    intermediates: ["else$1"]
+variable-regexp:
+  - "[a-z0-9]+"
+`
+
+func newTestEngine(t *testing.T, yaml string) (*ClassifierEngine, *config.ClassifierConfig) {
+	t.Helper()
+	cfg, err := config.ParseClassifierConfig([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := cfg.CompileRegexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClassifierEngine(compiled), cfg
+}
+
+// TestCloneIncremental checks that a clone of an engine whose mappings are
+// being extended has its own copy of them, which the original goes on
+// extending concurrently. Run with -race.
+func TestCloneIncremental(t *testing.T) {
+	engine, cfg := newTestEngine(t, cloneTestConfig)
+	if err := engine.BeginFormStartEndMappings(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ExtendFormStartEndMappings("ifa"); err != nil {
+		t.Fatal(err)
+	}
+	clone := engine.Clone()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			token := fmt.Sprintf("if%d", i)
+			if err := engine.ExtendFormStartEndMappings(token); err != nil {
+				t.Error(err)
+				return
+			}
+			if code := engine.Classify("else" + fmt.Sprint(i)).Code; code != "I" {
+				t.Errorf("original: else%d is %s, want I", i, code)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			if code := clone.Classify("elsea").Code; code != "I" {
+				t.Errorf("clone: elsea is %s, want I", code)
+				return
+			}
+			// The clone has a copy of the mappings as they were when
+			// it was made, so it does not see the later start tokens.
+			if code := clone.Classify("else1").Code; code != "V" {
+				t.Errorf("clone: else1 is %s, want V", code)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	// The clone extends its own copy.
+	if err := clone.ExtendFormStartEndMappings("ifb"); err != nil {
+		t.Fatal(err)
+	}
+	if code := clone.Classify("elseb").Code; code != "I" {
+		t.Errorf("clone: elseb is %s, want I", code)
+	}
+	if code := engine.Classify("elseb").Code; code != "V" {
+		t.Errorf("original: elseb is %s, want V", code)
+	}
+}
+
+// TestClonesConcurrently checks that engines cloned from one compiled
+// config give the same classifications concurrently as they do one at a
+// time, each building its mappings from its own tokens. Run with -race.
+func TestClonesConcurrently(t *testing.T) {
+	tokens := []string{"if", "x", "=", "1", "+=", "y", "fi", "while", "do", "{", "z", "}", "done", "?", "else", "begin", "beginfoo", "midfoo", "endfoo", "try", "catch", "endtry"}
+	for _, file := range []string{"simple-config.yaml", "intermediates-config.yaml", "pcre-config.yaml", "priority-config.yaml"} {
+		t.Run(file, func(t *testing.T) {
+			cfg, err := config.LoadClassifierConfig("../../functests/" + file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compiled, err := cfg.CompileRegexes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			engine := NewClassifierEngine(compiled)
+
+			// Each clone classifies a different stream, a rotation of the
+			// tokens, so that their mappings differ.
+			const clones = 8
+			streams := make([][]string, clones)
+			want := make([][]string, clones)
+			for i := range clones {
+				streams[i] = append(slices.Clone(tokens[i:]), tokens[:i]...)
+				want[i] = classifyAll(t, engine.Clone(), streams[i], cfg)
+			}
+
+			var wg sync.WaitGroup
+			for i := range clones {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					clone := engine.Clone()
+					for range 20 {
+						if got := classifyAll(t, clone, streams[i], cfg); !slices.Equal(got, want[i]) {
+							t.Errorf("clone %d: got %v, want %v", i, got, want[i])
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// classifyAll builds the mappings from the tokens and classifies them.
+func classifyAll(t *testing.T, engine *ClassifierEngine, tokens []string, cfg *config.ClassifierConfig) []string {
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		t.Error(err)
+		return nil
+	}
+	codes := make([]string, len(tokens))
+	for i, token := range tokens {
+		codes[i] = engine.ClassifyToken(token)
+	}
+	return codes
+}
@@ -0,0 +1,47 @@
+package classifier
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteConflicts reports each distinct token that more than one section
+// matches, listing the matches with the winner first. The form mappings must
+// already have been built. It returns the number of conflicting tokens.
+func (ce *ClassifierEngine) WriteConflicts(w io.Writer, tokens []string) (int, error) {
+	unique, _ := UniqueTokens(tokens)
+	bw := bufio.NewWriter(w)
+	count := 0
+	for _, token := range unique {
+		matches := ce.Matches(token)
+		if len(matches) < 2 {
+			continue
+		}
+		count++
+
+		// The highest priority wins, ties going to the earlier section.
+		win := 0
+		for i, c := range matches {
+			if c.Priority > matches[win].Priority {
+				win = i
+			}
+		}
+		fmt.Fprintln(bw, token)
+		writeMatch(bw, matches[win], "wins")
+		for i, c := range matches {
+			if i != win {
+				writeMatch(bw, c, "loses")
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return count, fmt.Errorf("error writing output: %w", err)
+	}
+	return count, nil
+}
+
+// writeMatch writes one line of a conflict report.
+func writeMatch(w io.Writer, c *Classification, outcome string) {
+	fmt.Fprintf(w, "  %-5s %s %s %q priority %d\n", outcome, c.Code, c.Section, c.Pattern, c.Priority)
+}
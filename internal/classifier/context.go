@@ -0,0 +1,42 @@
+package classifier
+
+import "github.com/sfkleach/re-classify/internal/config"
+
+// ClassifyAfter classifies a token that follows prev in the stream, which is
+// nil at the start of the input. The context rules of the config choose
+// between the classes that the token matches according to the class of the
+// previous token; without them it is the same as Classify.
+func (ce *ClassifierEngine) ClassifyAfter(prev *Classification, token string) *Classification {
+	c := ce.Classify(token)
+	rules := ce.config.ContextRules
+	if len(rules) == 0 || token == "" {
+		return c
+	}
+	after := config.StartOfInput
+	if prev != nil {
+		after = prev.Code
+	}
+	if normalize := ce.config.NormalizeToken; normalize != nil {
+		token = normalize(token)
+	}
+	for _, rule := range rules {
+		if !rule.After[after] || rule.Pattern.FindStringSubmatch(token) == nil {
+			continue
+		}
+		if c.Code == rule.Class {
+			return c
+		}
+		// A rule can only choose between the classes that the token
+		// matches, since they supply the details such as precedences.
+		for m := range ce.matches(token, nil) {
+			if m.Code == rule.Class {
+				m.Priority = ce.priority(m)
+				if codes := ce.config.OutputCodes; codes != nil {
+					m.OutputCode = codes[m.Code]
+				}
+				return m
+			}
+		}
+	}
+	return c
+}
@@ -0,0 +1,70 @@
+package classifier
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestClassifyDetailed checks the fields of detailed classifications,
+// including the codes renamed by output-codes.
+func TestClassifyDetailed(t *testing.T) {
+	tests := []struct {
+		config string
+		tokens []string // The tokens that build the mappings
+		token  string
+		want   Detail
+	}{
+		{"intermediates-config.yaml", []string{"beginfoo", "endfoo"}, "beginfoo", Detail{
+			Class:         "S",
+			Section:       "surround-regexp",
+			Pattern:       `begin(\w+)`,
+			CaptureGroups: []string{"beginfoo", "foo"},
+			EndTokens:     []string{"endfoo"},
+			Serial:        2,
+		}},
+		{"intermediates-config.yaml", []string{"beginfoo", "midfoo", "endfoo"}, "midfoo", Detail{
+			Class:         "I",
+			Section:       "surround-regexp",
+			Pattern:       "midfoo",
+			CaptureGroups: []string{"midfoo"},
+			Serial:        2,
+		}},
+		{"simple-config.yaml", nil, "+", Detail{
+			Class:         "O",
+			Section:       "operator-regexp",
+			Pattern:       `\+`,
+			CaptureGroups: []string{"+"},
+			Operator:      &OperatorInfo{InfixPrec: 50},
+			Serial:        -1,
+		}},
+		{"simple-config.yaml", nil, "?", Detail{
+			Class:  "U",
+			Serial: -1,
+		}},
+		{"output-codes-config.yaml", []string{"if", "fi"}, "if", Detail{
+			Class:         "FORM_START",
+			Section:       "surround-regexp",
+			Pattern:       "if",
+			CaptureGroups: []string{"if"},
+			EndTokens:     []string{"fi"},
+			Serial:        0,
+		}},
+		{"output-codes-config.yaml", nil, "+", Detail{
+			Class:         "OP",
+			Section:       "operator-regexp",
+			Pattern:       "[+]",
+			CaptureGroups: []string{"+"},
+			Operator:      &OperatorInfo{InfixPrec: 50},
+			Serial:        -1,
+		}},
+	}
+	for _, tt := range tests {
+		engine, cfg := loadTestEngine(t, tt.config)
+		if err := engine.BuildFormStartEndMappings(tt.tokens, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := engine.ClassifyDetailed(tt.token); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %s: got %+v, want %+v", tt.config, tt.token, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package classifier
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/regexptable"
+)
+
+// endGroups records which groups each form-end could close, given the start
+// tokens actually seen in the stream. A group whose start never occurs
+// cannot be closed, whatever its endings say.
+type endGroups struct {
+	compile  func(string) (regexptable.CompiledRegexp, error)
+	literal  map[string][]int // Form-ends spelled out by the endings of the starts seen
+	patterns []endGroupPattern
+	seen     map[int]bool // The groups with an end pattern whose start was seen
+}
+
+// endGroupPattern is the end pattern of a group whose start was seen.
+type endGroupPattern struct {
+	serial int
+	re     regexptable.CompiledRegexp
+}
+
+// expect records the form-ends that a start token of the group expects, by
+// substituting its capture groups into the endings.
+func (e *endGroups) expect(serial int, endings []string, captureGroups []string) {
+	for _, ending := range endings {
+		end := config.SubstitutePattern(ending, captureGroups)
+		if e.literal == nil {
+			e.literal = make(map[string][]int)
+		}
+		if !slices.Contains(e.literal[end], serial) {
+			e.literal[end] = append(e.literal[end], serial)
+		}
+	}
+}
+
+// expectPattern records that a start token of a group whose form-ends are
+// given by an end pattern was seen.
+func (e *endGroups) expectPattern(serial int, pattern string) error {
+	if e.seen[serial] {
+		return nil
+	}
+	re, err := e.compile(pattern)
+	if err != nil {
+		return err
+	}
+	if e.seen == nil {
+		e.seen = make(map[int]bool)
+	}
+	e.seen[serial] = true
+	e.patterns = append(e.patterns, endGroupPattern{serial, re})
+	return nil
+}
+
+// clone copies the form-ends expected so far.
+func (e *endGroups) clone() *endGroups {
+	c := &endGroups{compile: e.compile, patterns: slices.Clone(e.patterns), seen: maps.Clone(e.seen)}
+	for end, serials := range e.literal {
+		if c.literal == nil {
+			c.literal = make(map[string][]int, len(e.literal))
+		}
+		c.literal[end] = slices.Clone(serials)
+	}
+	return c
+}
+
+// closes returns the groups that the form-end could close, in order.
+func (e *endGroups) closes(token string) []int {
+	groups := slices.Clone(e.literal[token])
+	for _, p := range e.patterns {
+		if p.re.FindStringSubmatch(token) != nil && !slices.Contains(groups, p.serial) {
+			groups = append(groups, p.serial)
+		}
+	}
+	slices.Sort(groups)
+	return groups
+}
+
+// formatGroups renders serial numbers as a comma-separated list e.g. "0,3".
+func formatGroups(groups []int) string {
+	s := make([]string, len(groups))
+	for i, g := range groups {
+		s[i] = strconv.Itoa(g)
+	}
+	return strings.Join(s, ",")
+}
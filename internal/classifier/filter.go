@@ -0,0 +1,65 @@
+package classifier
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ClassFilter selects classifications by their code. A nil filter allows
+// everything.
+type ClassFilter struct {
+	only    map[string]bool
+	exclude map[string]bool
+}
+
+// NewClassFilter builds a filter from comma-separated lists of codes, which
+// may also be given by their long names. If only is non-empty just those codes
+// are allowed; codes in exclude are always rejected. It returns nil if both
+// lists are empty.
+func NewClassFilter(only, exclude string) (*ClassFilter, error) {
+	onlyCodes, err := parseCodes(only)
+	if err != nil {
+		return nil, err
+	}
+	excludeCodes, err := parseCodes(exclude)
+	if err != nil {
+		return nil, err
+	}
+	if onlyCodes == nil && excludeCodes == nil {
+		return nil, nil
+	}
+	return &ClassFilter{only: onlyCodes, exclude: excludeCodes}, nil
+}
+
+// Allows reports whether classifications with this code pass the filter.
+func (f *ClassFilter) Allows(code string) bool {
+	if f == nil {
+		return true
+	}
+	if f.only != nil && !f.only[code] {
+		return false
+	}
+	return !f.exclude[code]
+}
+
+// parseCodes parses a comma-separated list of classification codes.
+func parseCodes(list string) (map[string]bool, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	codes := make(map[string]bool)
+	for code := range strings.SplitSeq(list, ",") {
+		code = strings.TrimSpace(code)
+		for c, name := range longNames {
+			if code == name {
+				code = c
+			}
+		}
+		if !slices.Contains(Codes, code) {
+			return nil, fmt.Errorf("unknown classification code %q (expected one of %s)", code, strings.Join(Codes, ","))
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
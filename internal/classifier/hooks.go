@@ -0,0 +1,82 @@
+package classifier
+
+import (
+	"log/slog"
+
+	"github.com/sfkleach/re-classify/internal/hooks"
+)
+
+// SectionHooks is the section of the classifications that hooks make.
+const SectionHooks = "hooks"
+
+// lookupHooked finds the classification of a normalized token when there
+// are hooks: the before hooks are consulted in turn until one classifies the
+// token, then the regex tables, and then each of the after hooks, which may
+// replace the classification. A hook that fails is logged and ignored, and
+// one that can no longer run, because it timed out, is dropped.
+func (ce *ClassifierEngine) lookupHooked(token string, trace *Trace) *Classification {
+	var c *Classification
+	for i, hook := range ce.config.Hooks {
+		if !hook.Before {
+			continue
+		}
+		if c = ce.runHook(i, token, "", trace); c != nil {
+			return c
+		}
+	}
+	c = ce.lookupTables(token, trace)
+	for i, hook := range ce.config.Hooks {
+		if hook.Before {
+			continue
+		}
+		if replaced := ce.runHook(i, token, c.Code, trace); replaced != nil {
+			c = replaced
+		}
+	}
+	return c
+}
+
+// runHook passes a token and its code so far to the i'th hook, returning the
+// classification that the hook gives it or nil if it leaves it alone.
+func (ce *ClassifierEngine) runHook(i int, token, code string, trace *Trace) *Classification {
+	hook := ce.config.Hooks[i]
+	instance := ce.hookInstance(i)
+	if instance == nil {
+		return nil
+	}
+	result, err := instance.Classify(token, code)
+	if err != nil {
+		if instance.Closed() {
+			// An instance that has timed out cannot be called again.
+			slog.Warn("disabled a hook that failed", "token", token, "error", err)
+			ce.hookInstances[i] = nil
+			return nil
+		}
+		slog.Warn("ignored a hook that failed", "token", token, "error", err)
+		return nil
+	}
+	trace.record("hook", result != "", hook.Module.Name(), []string{token})
+	if result == "" {
+		return nil
+	}
+	return &Classification{Code: result, Section: SectionHooks, Pattern: hook.Module.Name(), CaptureGroups: []string{token}, Serial: -1}
+}
+
+// hookInstance returns the engine's instance of the i'th hook module,
+// instantiating the modules on first use, or nil if the module could not be
+// instantiated. Each engine has its own instances, since an instance must
+// not be shared between goroutines.
+func (ce *ClassifierEngine) hookInstance(i int) *hooks.Instance {
+	if ce.hookInstances == nil {
+		ce.hookInstances = make([]*hooks.Instance, len(ce.config.Hooks))
+		for j, hook := range ce.config.Hooks {
+			instance, err := hook.Module.Instantiate()
+			if err != nil {
+				slog.Warn("ignored a hook that could not be instantiated", "error", err)
+				continue
+			}
+			ce.hookInstances[j] = instance
+		}
+	}
+	return ce.hookInstances[i]
+}
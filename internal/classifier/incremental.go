@@ -0,0 +1,109 @@
+package classifier
+
+import (
+	"errors"
+	"io"
+	"maps"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// BeginFormStartEndMappings prepares the form mappings for a stream whose
+// tokens are not known in advance, so that it can be classified in a single
+// pass. Each token must then be passed to ExtendFormStartEndMappings before
+// it is classified, which adds the form-ends and intermediates backfilled
+// from it to the tables on the fly.
+//
+// The result is the same as that of BuildFormStartEndMappings, except that a
+// form-start only lists the endings inferred from its end pattern that have
+// been seen so far, and a form-end only closes the groups of the form-starts
+// seen so far. Endings given in the config are listed from the start, but
+// those that refer to capture groups, such as end$1, are only recognised
+// once a start has supplied the groups.
+func (ce *ClassifierEngine) BeginFormStartEndMappings(cfg *config.ClassifierConfig) error {
+	m, err := ce.beginFormMappings(cfg)
+	if err != nil {
+		return err
+	}
+	return ce.extendFormMappings(m)
+}
+
+// extendFormMappings builds the tables from the tokens observed so far and
+// has the patterns backfilled from later tokens added to them on the fly.
+func (ce *ClassifierEngine) extendFormMappings(m *formMappings) error {
+	if err := ce.finishFormMappings(m, 0); err != nil {
+		return err
+	}
+	m.endBackfills.table = ce.endTokenTable
+	m.operatorBackfills.table = ce.endTokenTable
+	m.intermediateBackfills.table = ce.intermediateTokenTable
+	ce.incremental = m
+	return nil
+}
+
+// cloneFormMappings gives the engine its own copy of form mappings that are
+// being built incrementally. Extending them adds patterns to the tables and
+// endings to the form-starts in place, so they cannot be shared.
+func (ce *ClassifierEngine) cloneFormMappings(from *formMappings) error {
+	m, err := ce.beginFormMappings(from.cfg)
+	if err != nil {
+		return err
+	}
+	for i, info := range from.startTokenInfoList {
+		if info != nil {
+			maps.Copy(m.startTokenInfoList[i].Endings, info.Endings)
+		}
+	}
+	m.endBackfills = from.endBackfills.clone()
+	m.operatorBackfills = from.operatorBackfills.clone()
+	m.intermediateBackfills = from.intermediateBackfills.clone()
+	m.closers = from.closers.clone()
+	return ce.extendFormMappings(m)
+}
+
+// ExtendFormStartEndMappings extends the form mappings begun by
+// BeginFormStartEndMappings with the next token of the stream.
+func (ce *ClassifierEngine) ExtendFormStartEndMappings(token string) error {
+	if ce.incremental == nil {
+		return errors.New("the form mappings are not being built incrementally")
+	}
+	return ce.incremental.observe(token)
+}
+
+// ProcessSinglePass is like Process but classifies each token as soon as it
+// is read, extending the form mappings as it goes, see
+// BeginFormStartEndMappings. Neither the tokens nor a spool of them are
+// kept. opts.Unique is not supported, since it needs every distinct token.
+func (ce *ClassifierEngine) ProcessSinglePass(r io.Reader, w io.Writer, cfg *config.ClassifierConfig, opts *ProcessOptions) error {
+	if opts == nil {
+		opts = &ProcessOptions{}
+	}
+	if opts.Unique {
+		return errors.New("unique tokens cannot be listed in a single pass")
+	}
+	if err := ce.BeginFormStartEndMappings(cfg); err != nil {
+		return err
+	}
+
+	scanner := NewTokenScannerWith(r, opts.MaxTokenBytes, ce.TokenPolicy())
+	var extendErr error
+	tokens := func(yield func(string) bool) {
+		for scanner.Scan() {
+			token := scanner.Token()
+			if extendErr = ce.ExtendFormStartEndMappings(token); extendErr != nil {
+				return
+			}
+			if !yield(token) {
+				return
+			}
+		}
+	}
+	inputLine := func(int) int { return scanner.Line() }
+	if err := ce.writeClassifications(w, tokens, nil, opts, inputLine); err != nil {
+		return err
+	}
+	if extendErr != nil {
+		return extendErr
+	}
+	return scanner.Err()
+}
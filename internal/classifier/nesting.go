@@ -0,0 +1,122 @@
+package classifier
+
+import (
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// Nesting tracks the forms that are open as a stream of tokens is
+// classified, so that each form-end closes the innermost open form that it
+// fits and each intermediate belongs to the innermost open form. Closures
+// that the nesting makes impossible are logged as warnings.
+type Nesting struct {
+	engine   *ClassifierEngine
+	logger   *slog.Logger
+	open     []openForm
+	position int // The 1-based position of the latest token
+	warnings int
+}
+
+// openForm is a form whose start has been seen but not its end.
+type openForm struct {
+	token         string
+	position      int
+	serial        int
+	ends          []string
+	intermediates []string
+}
+
+// NewNesting returns a Nesting for a stream classified by the engine, with
+// no forms open. The form mappings must already have been built.
+func (ce *ClassifierEngine) NewNesting() *Nesting {
+	return &Nesting{engine: ce, logger: slog.Default()}
+}
+
+// SetSource names the stream, e.g. after its file, in the warnings.
+func (n *Nesting) SetSource(name string) {
+	n.logger = slog.With("file", name)
+}
+
+// Warnings returns the number of warnings logged so far.
+func (n *Nesting) Warnings() int {
+	return n.warnings
+}
+
+// warn logs a warning about the nesting.
+func (n *Nesting) warn(msg string, args ...any) {
+	n.warnings++
+	n.logger.Warn(msg, args...)
+}
+
+// Resolve takes the classification of the next token of the stream and
+// returns it with a form-end or intermediate attributed to the open form
+// that it belongs to. Other classifications are returned unchanged.
+func (n *Nesting) Resolve(token string, c *Classification) *Classification {
+	n.position++
+	if normalize := n.engine.config.NormalizeToken; normalize != nil {
+		token = normalize(token)
+	}
+	switch {
+	case c.EndTokens != nil && (c.Code == "S" || c.Code == "O"):
+		form := openForm{token: token, position: n.position, serial: c.Serial, ends: c.EndTokens}
+		if c.Code == "S" && c.Serial < len(n.engine.groupIntermediates) {
+			for _, intermediate := range n.engine.groupIntermediates[c.Serial] {
+				form.intermediates = append(form.intermediates, config.SubstitutePattern(intermediate, c.CaptureGroups))
+			}
+		}
+		n.open = append(n.open, form)
+	case c.Code == "E":
+		return n.close(token, c)
+	case c.Code == "I":
+		if len(n.open) == 0 || !slices.Contains(n.open[len(n.open)-1].intermediates, token) {
+			n.warn("intermediate outside its form", "token", token, "position", n.position)
+			return c
+		}
+		serial := n.open[len(n.open)-1].serial
+		resolved := *c
+		resolved.Serial = serial
+		resolved.Data = []string{strconv.Itoa(serial)}
+		return &resolved
+	}
+	return c
+}
+
+// close pops the innermost open form that a form-end fits, together with
+// any forms opened inside it, which were left unclosed.
+func (n *Nesting) close(token string, c *Classification) *Classification {
+	i := len(n.open) - 1
+	for i >= 0 && !slices.Contains(n.open[i].ends, token) {
+		i--
+	}
+	if i < 0 {
+		n.warn("form-end closes no open form", "token", token, "position", n.position)
+		return c
+	}
+	for _, f := range slices.Backward(n.open[i+1:]) {
+		n.warn("form not closed before an enclosing form-end", "token", f.token, "position", f.position, "end", token, "end-position", n.position)
+	}
+	serial := n.open[i].serial
+	n.open = n.open[:i]
+
+	resolved := *c
+	resolved.Serial = serial
+	resolved.Groups = []int{serial}
+	if n.engine.reportEndGroups {
+		resolved.Data = []string{formatGroups(resolved.Groups)}
+	}
+	return &resolved
+}
+
+// Finish warns about the forms that are still open at the end of the
+// stream, innermost first, and reports how many there were.
+func (n *Nesting) Finish() int {
+	for _, f := range slices.Backward(n.open) {
+		n.warn("form never closed", "token", f.token, "position", f.position)
+	}
+	unclosed := len(n.open)
+	n.open = nil
+	return unclosed
+}
@@ -0,0 +1,194 @@
+package classifier
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// The sources of the endings and patterns in a plan.
+const (
+	PlanExplicit    = "explicit"    // Given in the config
+	PlanInferred    = "inferred"    // A token that matched the group's end pattern
+	PlanSynthesized = "synthesized" // Made from an ending, substituting $0
+	PlanBackfilled  = "backfilled"  // Made from a token that starts the group
+)
+
+// Plan describes what building the form mappings decided: where the endings
+// of each group came from, and the final contents of the tables of
+// form-starts, form-ends and intermediates.
+type Plan struct {
+	Groups        []PlanGroup // The surround groups, then the form-starting operators
+	Starts        []PlanEntry
+	Ends          []PlanEntry
+	Intermediates []PlanEntry
+
+	surrounds int // The number of surround groups, which the operators follow
+}
+
+// PlanGroup describes a surround group or form-starting operator.
+type PlanGroup struct {
+	Serial  int    // As in the end groups, see SetReportEndGroups
+	Section string // SectionSurround or SectionOperator
+	Pattern string // The pattern that starts the group
+	Endings []PlanEntry
+}
+
+// PlanEntry is an ending of a group or a pattern of a table, and where it
+// came from.
+type PlanEntry struct {
+	Pattern string
+	Serial  int    // The group it belongs to
+	Section string // The section of the group
+	Source  string // One of the Plan sources, e.g. PlanBackfilled
+
+	// From is what the entry was made from: the ending a synthesized
+	// pattern was made from, the token a backfilled pattern was made from,
+	// or the end pattern that an inferred ending matched.
+	From string
+}
+
+// PlanFormStartEndMappings builds the form mappings from the tokens, as
+// BuildFormStartEndMappings does, and returns a plan of what it decided.
+func (ce *ClassifierEngine) PlanFormStartEndMappings(tokens []string, cfg *config.ClassifierConfig) (*Plan, error) {
+	m, err := ce.buildFormMappings(slices.Values(tokens), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return m.plan(), nil
+}
+
+// plan describes the form mappings once they are built, following the order
+// in which the patterns were added to the tables.
+func (m *formMappings) plan() *Plan {
+	cfg := m.cfg
+	p := &Plan{surrounds: len(cfg.SurroundRegexp)}
+
+	for i, surroundConfig := range cfg.SurroundRegexp {
+		group := PlanGroup{Serial: i, Section: SectionSurround, Pattern: surroundConfig.StartPattern()}
+		if len(surroundConfig.Endings) > 0 {
+			group.Endings = explicitEntries(surroundConfig.Endings, i, SectionSurround)
+		} else if info := m.startTokenInfoList[i]; info != nil {
+			for _, ending := range slices.Sorted(maps.Keys(info.Endings)) {
+				group.Endings = append(group.Endings, PlanEntry{Pattern: ending, Serial: i, Section: SectionSurround, Source: PlanInferred, From: surroundConfig.End})
+			}
+		}
+		p.Groups = append(p.Groups, group)
+	}
+	for i, opConfig := range cfg.OperatorRegexp {
+		if len(opConfig.EndTokens) > 0 {
+			serial := len(cfg.SurroundRegexp) + i
+			p.Groups = append(p.Groups, PlanGroup{Serial: serial, Section: SectionOperator, Pattern: opConfig.Pattern, Endings: explicitEntries(opConfig.EndTokens, serial, SectionOperator)})
+		}
+	}
+
+	for _, i := range cfg.SurroundOrder() {
+		for _, start := range cfg.SurroundRegexp[i].StartPatterns() {
+			p.Starts = append(p.Starts, PlanEntry{Pattern: start, Serial: i, Section: SectionSurround, Source: PlanExplicit})
+		}
+	}
+
+	for i, surroundConfig := range cfg.SurroundRegexp {
+		if surroundConfig.End != "" {
+			p.Ends = append(p.Ends, PlanEntry{Pattern: surroundConfig.End, Serial: i, Section: SectionSurround, Source: PlanExplicit})
+			continue
+		}
+		p.Ends = appendSynthesized(p.Ends, surroundConfig.StartPattern(), surroundConfig.Endings, i, SectionSurround)
+	}
+	for i, opConfig := range cfg.OperatorRegexp {
+		p.Ends = appendSynthesized(p.Ends, opConfig.Pattern, opConfig.EndTokens, len(cfg.SurroundRegexp)+i, SectionOperator)
+	}
+	p.Ends = m.endBackfills.appendTo(p.Ends)
+	p.Ends = m.operatorBackfills.appendTo(p.Ends)
+
+	if m.intermediates > 0 {
+		for i, surroundConfig := range cfg.SurroundRegexp {
+			p.Intermediates = appendSynthesized(p.Intermediates, surroundConfig.StartPattern(), surroundConfig.Intermediates, i, SectionSurround)
+		}
+		p.Intermediates = m.intermediateBackfills.appendTo(p.Intermediates)
+	}
+	return p
+}
+
+// explicitEntries returns the endings given in the config for a group.
+func explicitEntries(endings []string, serial int, section string) []PlanEntry {
+	entries := make([]PlanEntry, len(endings))
+	for i, ending := range endings {
+		entries[i] = PlanEntry{Pattern: ending, Serial: serial, Section: section, Source: PlanExplicit}
+	}
+	return entries
+}
+
+// appendSynthesized appends the patterns synthesized from the endings of a
+// group, as addEndPatterns adds them to a table.
+func appendSynthesized(entries []PlanEntry, start string, endings []string, serial int, section string) []PlanEntry {
+	for _, ending := range endings {
+		if pattern, ok := endPattern(start, ending); ok {
+			entries = append(entries, PlanEntry{Pattern: pattern, Serial: serial, Section: section, Source: PlanSynthesized, From: ending})
+		}
+	}
+	return entries
+}
+
+// appendTo appends the backfilled patterns, with the tokens they were
+// backfilled from.
+func (b *backfills) appendTo(entries []PlanEntry) []PlanEntry {
+	for i, info := range b.infos {
+		entries = append(entries, PlanEntry{Pattern: info.Pattern, Serial: info.SerialNumber, Section: info.Section, Source: PlanBackfilled, From: b.tokens[i]})
+	}
+	return entries
+}
+
+// Write writes the plan as text: each group with its endings, then the
+// contents of each table.
+func (p *Plan) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, group := range p.Groups {
+		fmt.Fprintf(bw, "%s %q\n", p.groupName(group.Section, group.Serial), group.Pattern)
+		if len(group.Endings) == 0 {
+			fmt.Fprintln(bw, "  no endings")
+		}
+		for _, ending := range group.Endings {
+			fmt.Fprintf(bw, "  %s ending %q", ending.Source, ending.Pattern)
+			if ending.From != "" {
+				fmt.Fprintf(bw, " from end pattern %q", ending.From)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	p.writeTable(bw, "start table", p.Starts)
+	p.writeTable(bw, "end table", p.Ends)
+	p.writeTable(bw, "intermediate table", p.Intermediates)
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	return nil
+}
+
+// writeTable writes the contents of a table, a line per pattern.
+func (p *Plan) writeTable(w io.Writer, title string, entries []PlanEntry) {
+	fmt.Fprintln(w, title)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "  %q %s %s", entry.Pattern, p.groupName(entry.Section, entry.Serial), entry.Source)
+		switch entry.Source {
+		case PlanSynthesized:
+			fmt.Fprintf(w, " from ending %q", entry.From)
+		case PlanBackfilled:
+			fmt.Fprintf(w, " from token %q", entry.From)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// groupName names a group by its place in the config, e.g.
+// surround-regexp[2].
+func (p *Plan) groupName(section string, serial int) string {
+	if section == SectionOperator {
+		return fmt.Sprintf("operator-regexp[%d]", serial-p.surrounds)
+	}
+	return fmt.Sprintf("surround-regexp[%d]", serial)
+}
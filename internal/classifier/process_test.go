@@ -0,0 +1,94 @@
+package classifier
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// loadTestEngine is like newTestEngine but reads the config from a file in
+// the functests directory.
+func loadTestEngine(t *testing.T, file string) (*ClassifierEngine, *config.ClassifierConfig) {
+	t.Helper()
+	data, err := os.ReadFile("../../functests/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestEngine(t, string(data))
+}
+
+// errWriter fails every write after the first n bytes.
+type errWriter struct {
+	n   int
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		written := w.n
+		w.n = 0
+		return written, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// TestProcess checks that Process writes to the writer it is given rather
+// than to stdout.
+func TestProcess(t *testing.T) {
+	engine, cfg := loadTestEngine(t, "simple-config.yaml")
+
+	var out bytes.Buffer
+	if err := engine.Process(strings.NewReader("if\nx\n+\nfi\n?\n"), &out, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "S fi\nV\nO 0 50 0\nE\nU\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	opts := &ProcessOptions{ShowTokens: true, Header: "# tokens\n"}
+	if err := engine.Process(strings.NewReader("x\n"), &out, cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := "# tokens\nx\tV\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+// TestProcessWriteError checks that a failed write is returned, whether it
+// happens when the output is flushed at the end or after each token.
+func TestProcessWriteError(t *testing.T) {
+	engine, cfg := loadTestEngine(t, "simple-config.yaml")
+	errFull := errors.New("disk full")
+
+	for _, unbuffered := range []bool{false, true} {
+		w := &errWriter{n: 2, err: errFull}
+		opts := &ProcessOptions{Unbuffered: unbuffered}
+		err := engine.Process(strings.NewReader("x\ny\nz\n"), w, cfg, opts)
+		if !errors.Is(err, errFull) {
+			t.Errorf("unbuffered=%v: got %v, want %v", unbuffered, err, errFull)
+		}
+	}
+}
+
+// TestWriteClassifications checks that classifications can be written for
+// tokens that have already been read, e.g. by the caller.
+func TestWriteClassifications(t *testing.T) {
+	engine, cfg := loadTestEngine(t, "simple-config.yaml")
+	if err := engine.BuildFormStartEndMappings([]string{"if"}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	opts := &ProcessOptions{ShowTokens: true, LongNames: true}
+	if err := engine.WriteClassifications(&out, []string{"if", "x"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := "if\tform-start fi\nx\tvariable\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,106 @@
+package classifier
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Profile accumulates, for each pattern, the number of tokens that it
+// classified and the time taken to classify them. The patterns of a table
+// are matched together, so the time of each classification is charged to
+// the pattern that won it; a pattern that is expensive to match shows up as
+// an expensive classification. A Profile may be shared by clones of an
+// engine.
+type Profile struct {
+	mu    sync.Mutex
+	stats map[patternKey]*PatternStats
+}
+
+// PatternStats are the statistics of a single pattern.
+type PatternStats struct {
+	Section string        // The section of the pattern, empty for unclassified tokens
+	Pattern string        // The pattern
+	Hits    int           // The number of tokens that it classified
+	Time    time.Duration // The time taken to classify them
+}
+
+// patternKey identifies a pattern.
+type patternKey struct {
+	section, pattern string
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{stats: make(map[patternKey]*PatternStats)}
+}
+
+// SetProfile records every subsequent classification in the profile. Pass
+// nil to stop profiling.
+func (ce *ClassifierEngine) SetProfile(profile *Profile) {
+	ce.profile = profile
+}
+
+// record charges the time taken by a classification to its pattern.
+func (p *Profile) record(c *Classification, d time.Duration) {
+	key := patternKey{c.Section, c.Pattern}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats[key]
+	if stats == nil {
+		stats = &PatternStats{Section: c.Section, Pattern: c.Pattern}
+		p.stats[key] = stats
+	}
+	stats.Hits++
+	stats.Time += d
+}
+
+// Stats returns the statistics of the patterns that classified at least one
+// token, the most time-consuming first.
+func (p *Profile) Stats() []PatternStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]PatternStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		stats = append(stats, *s)
+	}
+	slices.SortFunc(stats, func(a, b PatternStats) int {
+		return cmp.Or(
+			cmp.Compare(b.Time, a.Time),
+			cmp.Compare(b.Hits, a.Hits),
+			cmp.Compare(a.Section, b.Section),
+			cmp.Compare(a.Pattern, b.Pattern),
+		)
+	})
+	return stats
+}
+
+// Write writes the statistics as a table, the most time-consuming pattern
+// first, with each pattern's share of the total time.
+func (p *Profile) Write(w io.Writer) error {
+	stats := p.Stats()
+	var total time.Duration
+	for _, s := range stats {
+		total += s.Time
+	}
+	if _, err := fmt.Fprintf(w, "%12s %7s %9s  %-22s %s\n", "time", "share", "hits", "section", "pattern"); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(s.Time) / float64(total)
+		}
+		section, pattern := s.Section, fmt.Sprintf("%q", s.Pattern)
+		if section == "" {
+			section, pattern = "(unclassified)", "-"
+		}
+		if _, err := fmt.Fprintf(w, "%12s %6.1f%% %9d  %-22s %s\n", s.Time.Round(time.Microsecond), share, s.Hits, section, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package classifier
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// ProcessSpooled is like Process but does not hold the tokens in memory, so
+// that streams of any size can be classified. While the form mappings are
+// built the tokens are spooled to a temporary file in dir (os.TempDir if
+// empty), which is then read back to classify them. Only the distinct
+// form-starts and form-ends are kept in memory. opts.Unique is not supported,
+// since it needs every distinct token.
+func (ce *ClassifierEngine) ProcessSpooled(r io.Reader, w io.Writer, cfg *config.ClassifierConfig, opts *ProcessOptions, dir string) (err error) {
+	if opts == nil {
+		opts = &ProcessOptions{}
+	}
+	if opts.Unique {
+		return errors.New("unique tokens cannot be listed without holding them in memory")
+	}
+
+	spool, err := os.CreateTemp(dir, "re-classify-spool-*")
+	if err != nil {
+		return fmt.Errorf("error creating spool file: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, spool.Close(), os.Remove(spool.Name()))
+	}()
+
+	// First pass: build the form mappings, copying the tokens to the spool.
+	scanner := NewTokenScannerWith(r, opts.MaxTokenBytes, ce.TokenPolicy())
+	spooler := bufio.NewWriter(spool)
+	var spoolErr error
+	tokens := func(yield func(string) bool) {
+		for scanner.Scan() {
+			token := scanner.Token()
+			if _, spoolErr = spooler.WriteString(token + "\n"); spoolErr != nil {
+				return
+			}
+			if !yield(token) {
+				return
+			}
+		}
+	}
+	if err := ce.BuildFormStartEndMappingsSeq(tokens, cfg); err != nil {
+		return fmt.Errorf("error building form mappings: %w", err)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if spoolErr == nil {
+		spoolErr = spooler.Flush()
+	}
+	if spoolErr != nil {
+		return fmt.Errorf("error writing spool file: %w", spoolErr)
+	}
+
+	// Second pass: classify the spooled tokens.
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error rewinding spool file: %w", err)
+	}
+	scanner = NewTokenScannerWith(spool, opts.MaxTokenBytes, ce.TokenPolicy())
+	tokens = func(yield func(string) bool) {
+		for scanner.Scan() {
+			if !yield(scanner.Token()) {
+				return
+			}
+		}
+	}
+	// The lines of the spool are not those of the input.
+	if err := ce.writeClassifications(w, tokens, nil, opts, nil); err != nil {
+		return err
+	}
+	return scanner.Err()
+}
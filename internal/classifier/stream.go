@@ -0,0 +1,31 @@
+package classifier
+
+import (
+	"context"
+	"iter"
+)
+
+// Classifications returns an iterator that classifies tokens lazily, as they
+// are drawn from the tokens sequence, yielding each token together with its
+// classification. This lets embedding applications consume results
+// incrementally without building large slices.
+//
+// The form mappings must already have been built, because inferred form-ends
+// depend on the whole token stream. Each token is classified in the context
+// of the one before, as the context rules direct. The iteration stops early
+// if ctx is cancelled; callers should check ctx.Err() afterwards to tell a
+// cancelled iteration from a complete one.
+func (ce *ClassifierEngine) Classifications(ctx context.Context, tokens iter.Seq[string]) iter.Seq2[string, *Classification] {
+	return func(yield func(string, *Classification) bool) {
+		var prev *Classification
+		for token := range tokens {
+			if ctx.Err() != nil {
+				return
+			}
+			prev = ce.ClassifyAfter(prev, token)
+			if !yield(token, prev) {
+				return
+			}
+		}
+	}
+}
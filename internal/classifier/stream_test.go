@@ -0,0 +1,88 @@
+package classifier
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// TestClassifications checks that the iterator yields each token with its
+// classification, in order.
+func TestClassifications(t *testing.T) {
+	engine, cfg := loadTestEngine(t, "simple-config.yaml")
+	tokens := []string{"if", "x", "+", "fi", "?"}
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for token, c := range engine.Classifications(context.Background(), slices.Values(tokens)) {
+		got = append(got, token+" "+c.String())
+	}
+	want := []string{"if S fi", "x V", "+ O 0 50 0", "fi E", "? U"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestClassificationsCancel checks that cancelling the context stops the
+// iteration without drawing further tokens.
+func TestClassificationsCancel(t *testing.T) {
+	engine, cfg := loadTestEngine(t, "simple-config.yaml")
+	if err := engine.BeginFormStartEndMappings(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	drawn := 0
+	tokens := func(yield func(string) bool) {
+		for drawn < 100 {
+			drawn++
+			if !yield("x") {
+				return
+			}
+		}
+	}
+	classified := 0
+	for range engine.Classifications(ctx, tokens) {
+		classified++
+		if classified == 3 {
+			cancel()
+		}
+	}
+	if classified != 3 {
+		t.Errorf("classified %d tokens after cancelling, want 3", classified)
+	}
+	if drawn > 4 {
+		t.Errorf("drew %d tokens, want at most 4", drawn)
+	}
+	if ctx.Err() == nil {
+		t.Error("the context was not cancelled")
+	}
+}
+
+// TestClassificationsBreak checks that breaking out of the loop stops the
+// iteration.
+func TestClassificationsBreak(t *testing.T) {
+	engine, cfg := loadTestEngine(t, "simple-config.yaml")
+	if err := engine.BeginFormStartEndMappings(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	drawn := 0
+	tokens := func(yield func(string) bool) {
+		for drawn < 100 {
+			drawn++
+			if !yield("x") {
+				return
+			}
+		}
+	}
+	for range engine.Classifications(context.Background(), tokens) {
+		break
+	}
+	if drawn != 1 {
+		t.Errorf("drew %d tokens, want 1", drawn)
+	}
+}
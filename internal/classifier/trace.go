@@ -0,0 +1,60 @@
+package classifier
+
+// Names of the tables consulted during classification, in the order that
+// they are consulted.
+const (
+	TableComment       = "comment"
+	TableString        = "string"
+	TableNumber        = "number"
+	TableCompoundLabel = "compound-label"
+	TableSimpleLabel   = "simple-label"
+	TableFormPrefix    = "form-prefix"
+	TableFormStart     = "form-start"
+	TableFormEnd       = "form-end"
+	TableIntermediate  = "intermediate"
+	TableOperator      = "operator"
+	TableVariable      = "variable"
+	TableOpenBracket   = "open-bracket"
+	TableCloseBracket  = "close-bracket"
+)
+
+// TraceStep records the outcome of consulting a single table.
+type TraceStep struct {
+	Table         string   // Which table was consulted
+	Hit           bool     // Whether the token matched
+	Pattern       string   // The matching pattern, if any
+	CaptureGroups []string // The match groups, if any
+	ExceptedBy    string   // The except pattern that vetoed the match, if any
+}
+
+// Trace is the lookup path taken while classifying a token. Tables that are
+// not configured are not consulted and do not appear.
+type Trace struct {
+	Token string
+	Steps []TraceStep
+}
+
+// record appends a step to the trace. It is a no-op on a nil trace so that
+// the classifier does not need to check whether it is tracing.
+func (t *Trace) record(table string, hit bool, pattern string, groups []string) {
+	if t == nil {
+		return
+	}
+	step := TraceStep{Table: table, Hit: hit}
+	if hit {
+		step.Pattern = pattern
+		step.CaptureGroups = groups
+	}
+	t.Steps = append(t.Steps, step)
+}
+
+// except marks the most recent step as a match that was vetoed by an except
+// pattern.
+func (t *Trace) except(pattern string) {
+	if t == nil || len(t.Steps) == 0 {
+		return
+	}
+	step := &t.Steps[len(t.Steps)-1]
+	step.Hit = false
+	step.ExceptedBy = pattern
+}
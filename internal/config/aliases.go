@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeyAlias is an old name of a config key, which is still read as the key
+// that replaced it, with a warning, until the version that removes it.
+type KeyAlias struct {
+	Parent    string // The section whose items have the key, or "" for the top level
+	Old       string
+	New       string
+	RemovedIn string // The version that will no longer read Old
+}
+
+// KeyAliases lists the old names of config keys. To rename a key, change its
+// yaml tag and add the old name here, so that existing configs keep working.
+var KeyAliases = []KeyAlias{
+	// The bracket pairs were documented as bracket-regexp.
+	{Old: "bracket-regexp", New: "bracket-pairs", RemovedIn: "v0.4.0"},
+}
+
+// renameAliases renames the old keys of a parsed config to the keys that
+// replaced them, passing each to found together with where it was found,
+// e.g. surround-regexp[2].ending. It is an error to give both the old key
+// and its replacement.
+func renameAliases(doc *yaml.Node, found func(alias KeyAlias, key string, line int)) error {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+	// The top-level keys come first, since they may be the parents of the
+	// others.
+	for _, alias := range KeyAliases {
+		if alias.Parent == "" {
+			if err := renameAlias(doc, alias, alias.Old, found); err != nil {
+				return err
+			}
+		}
+	}
+	for _, alias := range KeyAliases {
+		if alias.Parent == "" {
+			continue
+		}
+		section := mappingValue(doc, alias.Parent)
+		if section == nil {
+			continue
+		}
+		switch section.Kind {
+		case yaml.MappingNode:
+			if err := renameAlias(section, alias, alias.Parent+"."+alias.Old, found); err != nil {
+				return err
+			}
+		case yaml.SequenceNode:
+			for i, item := range section.Content {
+				if item.Kind != yaml.MappingNode {
+					continue
+				}
+				if err := renameAlias(item, alias, fmt.Sprintf("%s[%d].%s", alias.Parent, i, alias.Old), found); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renameAlias renames the old key of an alias in a mapping, if it is there.
+func renameAlias(mapping *yaml.Node, alias KeyAlias, key string, found func(KeyAlias, string, int)) error {
+	old := mappingKey(mapping, alias.Old)
+	if old == nil {
+		return nil
+	}
+	if mappingKey(mapping, alias.New) != nil {
+		return fmt.Errorf("line %d: %s is deprecated in favour of %s, which is also given", old.Line, key, alias.New)
+	}
+	old.Value = alias.New
+	found(alias, key, old.Line)
+	return nil
+}
+
+// mappingKey returns the node of a key of a mapping, or nil if it has no
+// such key.
+func mappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value of a key of a mapping, or nil if it has no
+// such key.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// warnAlias warns that a config read from name uses an old key.
+func warnAlias(name string) func(KeyAlias, string, int) {
+	return func(alias KeyAlias, key string, line int) {
+		attrs := []any{"key", key, "replacement", alias.New, "removal", alias.RemovedIn, "line", line}
+		if name != "" {
+			attrs = append([]any{"file", name}, attrs...)
+		}
+		slog.Warn("deprecated config key", attrs...)
+	}
+}
+
+// UpgradeKeys renames the old keys of a config document, as read by
+// yaml.Unmarshal into a yaml.Node, to the keys that replaced them, so that
+// the config can be rewritten without them.
+func UpgradeKeys(doc *yaml.Node) error {
+	return renameAliases(doc, func(KeyAlias, string, int) {})
+}
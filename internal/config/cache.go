@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// LoadClassifierConfigCached is like LoadClassifierConfig but keeps a cache
+// of parsed configurations in cacheDir, keyed by a hash of the
+// config file's content, so that subsequent invocations with an unchanged
+// config skip parsing the YAML. Compiled regexes cannot be serialized, so the
+// regex compilation, usually the larger part of loading a config, is not
+// cached and the saving is small. The cache is only an
+// optimization: if it cannot be read or written the config is loaded
+// normally. The configs that a config extends are not cached, so that changes
+// to them take effect.
+func LoadClassifierConfigCached(filename string, cacheDir string) (*ClassifierConfig, error) {
+	data, err := ReadSource(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", filename, err)
+	}
+
+	cachePath := filepath.Join(cacheDir, cacheKey(data)+".gob")
+	if cached, err := readCachedConfig(cachePath); err == nil {
+		slog.Debug("loaded config from cache", "file", filename, "cache", cachePath)
+		return cached.ResolveExtends(filename)
+	}
+
+	config, deprecated, err := parseClassifierConfig(data, filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+
+	// A config with old keys is not cached, so that it is warned about
+	// every time it is loaded.
+	if deprecated {
+		return config.ResolveExtends(filename)
+	}
+	if err := writeCachedConfig(cachePath, config); err != nil {
+		slog.Warn("could not write config cache", "cache", cachePath, "error", err)
+	} else {
+		slog.Debug("saved config to cache", "file", filename, "cache", cachePath)
+	}
+
+	return config.ResolveExtends(filename)
+}
+
+// cacheKey identifies a cache entry. It covers the shape of ClassifierConfig
+// as well as the config text, so entries written by a build with a different
+// configuration schema are never reused.
+func cacheKey(data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(schemaFingerprint(reflect.TypeOf(ClassifierConfig{}))))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// schemaFingerprint describes a type's structure: field names, tags and types.
+func schemaFingerprint(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct:
+		var sb strings.Builder
+		sb.WriteString("struct{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(&sb, "%s %s %q;", f.Name, schemaFingerprint(f.Type), f.Tag)
+		}
+		sb.WriteString("}")
+		return sb.String()
+	case reflect.Slice:
+		return "[]" + schemaFingerprint(t.Elem())
+	case reflect.Map:
+		return "map[" + schemaFingerprint(t.Key()) + "]" + schemaFingerprint(t.Elem())
+	case reflect.Pointer:
+		return "*" + schemaFingerprint(t.Elem())
+	default:
+		return t.String()
+	}
+}
+
+func readCachedConfig(path string) (*ClassifierConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304, the path is derived from a hash.
+	if err != nil {
+		return nil, err
+	}
+	var config ClassifierConfig
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// writeCachedConfig writes the cache entry via a temporary file so that
+// concurrent invocations never see a partially written entry.
+func writeCachedConfig(path string, config *ClassifierConfig) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(config); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "config-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,22 +2,67 @@ package config
 
 import (
 	"fmt"
-	"os"
+	"log/slog"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/sfkleach/regexptable"
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultNumberRegexp is added to number-regexp by builtin-numbers:
+// decimal, hexadecimal, octal and binary integers and decimal floats, all
+// allowing underscores between digits.
+var DefaultNumberRegexp = []string{
+	`[0-9](?:_?[0-9])*`,
+	`0[xX][0-9a-fA-F](?:_?[0-9a-fA-F])*`,
+	`0[oO][0-7](?:_?[0-7])*`,
+	`0[bB][01](?:_?[01])*`,
+	`[0-9](?:_?[0-9])*\.[0-9](?:_?[0-9])*(?:[eE][+-]?[0-9]+)?`,
+	`[0-9](?:_?[0-9])*[eE][+-]?[0-9]+`,
+}
+
+// PatternSections lists the sections that may have except patterns and
+// priorities. The bracket pairs are literal and so have neither.
+var PatternSections = []string{
+	"surround-regexp",
+	"form-prefix-regexp",
+	"simple-label-regexp",
+	"compound-label-regexp",
+	"variable-regexp",
+	"operator-regexp",
+	"comment-regexp",
+	"string-regexp",
+	"number-regexp",
+}
+
 // Pre-compiled regex for detecting non-zero substitution variables
 var nonZeroSubstRegex = regexp.MustCompile(`\$[1-9]`)
 
 // SurroundRegexpConfig represents a start/endings pair with regex substitution
 type SurroundRegexpConfig struct {
-	Start   string   `yaml:"start"`
-	End     string   `yaml:"end"`
-	Endings []string `yaml:"endings"`
+	Start         string   `yaml:"start"`
+	Starts        []string `yaml:"starts,omitempty"` // Synonymous openers e.g. def and define
+	End           string   `yaml:"end"`
+	Endings       []string `yaml:"endings"`
+	Intermediates []string `yaml:"intermediates,omitempty"` // e.g. else, elif
+}
+
+// StartPatterns returns the patterns that start the group: start, if any,
+// followed by starts.
+func (s SurroundRegexpConfig) StartPatterns() []string {
+	if s.Start == "" {
+		return s.Starts
+	}
+	return append([]string{s.Start}, s.Starts...)
+}
+
+// StartPattern returns a single pattern matching all the starts of the
+// group. It identifies the group in the priority section and in reports.
+func (s SurroundRegexpConfig) StartPattern() string {
+	return strings.Join(s.StartPatterns(), "|")
 }
 
 // OperatorConfig represents operator configuration with three precedence values
@@ -36,28 +81,96 @@ type BracketPairsConfig struct {
 	Outfix bool   `yaml:"outfix,omitempty"` // Whether this bracket can appear outfix
 }
 
-func (b *BracketPairsConfig) GetCode() string {
-	code := 0
+// GetFlag returns the delimiter flag: bit 0 for infix, bit 1 for outfix.
+func (b *BracketPairsConfig) GetFlag() int {
+	flag := 0
 	if b.Infix {
-		code += 1
+		flag += 1
 	}
 	if b.Outfix {
-		code += 2
+		flag += 2
 	}
-	return fmt.Sprintf("[ %d", code)
+	return flag
+}
+
+func (b *BracketPairsConfig) GetCode() string {
+	return fmt.Sprintf("[ %d", b.GetFlag())
 }
 
 // ClassifierConfig represents the configuration structure for the re-classify tool
 type ClassifierConfig struct {
+	// Extends names a config that this one builds on, resolved relative to
+	// this one; see ResolveExtends.
+	Extends string `yaml:"extends,omitempty"`
+
 	SurroundRegexp      []SurroundRegexpConfig `yaml:"surround-regexp,omitempty"`
 	FormPrefixRegexp    []string               `yaml:"form-prefix-regexp,omitempty"`
 	SimpleLabelRegexp   []string               `yaml:"simple-label-regexp,omitempty"`
 	CompoundLabelRegexp []string               `yaml:"compound-label-regexp,omitempty"`
 	VariableRegexp      []string               `yaml:"variable-regexp,omitempty"`
+	CommentRegexp       []string               `yaml:"comment-regexp,omitempty"`
+	StringRegexp        []string               `yaml:"string-regexp,omitempty"`
+	BuiltinNumbers      bool                   `yaml:"builtin-numbers,omitempty"` // Adds DefaultNumberRegexp to NumberRegexp
+	NumberRegexp        []string               `yaml:"number-regexp,omitempty"`
 	BracketPairs        []BracketPairsConfig   `yaml:"bracket-pairs,omitempty"`
 
+	// Words files list words, one per line, that a section matches literally
+	// as well as by its patterns, for vocabularies too large to write as
+	// patterns. They are read relative to the config.
+	FormPrefixWordsFile    string `yaml:"form-prefix-words-file,omitempty"`
+	SimpleLabelWordsFile   string `yaml:"simple-label-words-file,omitempty"`
+	CompoundLabelWordsFile string `yaml:"compound-label-words-file,omitempty"`
+	VariableWordsFile      string `yaml:"variable-words-file,omitempty"`
+
 	// Operator configurations with precedence values
 	OperatorRegexp []OperatorConfig `yaml:"operator-regexp,omitempty"`
+
+	// Patterns that veto a match in the named section, e.g. reserved words
+	// that would otherwise match variable-regexp.
+	Except map[string][]string `yaml:"except,omitempty"`
+
+	// Priority overrides the order of the sections for individual patterns.
+	// It maps a section name and one of its patterns onto a priority; the
+	// match with the highest priority wins and the rest have priority 0.
+	Priority map[string]map[string]int `yaml:"priority,omitempty"`
+
+	// How to choose between several patterns of a section that match the
+	// same token: first (the default) or most-specific.
+	MatchStrategy string `yaml:"match-strategy,omitempty"`
+
+	// The regular expression engine: re2 (the default) or pcre, which adds
+	// backreferences and lookarounds at the cost of speed.
+	RegexEngine string `yaml:"regex-engine,omitempty"`
+
+	// Unicode normal form applied to tokens and patterns before matching:
+	// none (the default), nfc, nfd, nfkc or nfkd.
+	UnicodeNormalization string `yaml:"unicode-normalization,omitempty"`
+
+	// How the lines of the input become tokens: token-whitespace is trim
+	// (the default) or preserve, and blank-tokens is skip (the default) or
+	// keep. See TokenPolicy.
+	TokenWhitespace string `yaml:"token-whitespace,omitempty"`
+	BlankTokens     string `yaml:"blank-tokens,omitempty"`
+
+	// What to do with a line of the input that is not valid UTF-8:
+	// pass-through (the default), replace or error. See TokenPolicy.
+	InvalidUTF8 string `yaml:"invalid-utf8,omitempty"`
+
+	// Case folding applied to tokens before matching: none (the default) or
+	// lower. Patterns are not folded and so should be written in lowercase.
+	CaseFolding string `yaml:"case-folding,omitempty"`
+
+	// OutputCodes renames classification codes in the output, e.g. S to
+	// FORM_START. Several codes may share a name, collapsing their classes.
+	OutputCodes map[string]string `yaml:"output-codes,omitempty"`
+
+	// ContextRules choose between the classes of a token by the class of
+	// the token before it.
+	ContextRules []ContextRuleConfig `yaml:"context-rules,omitempty"`
+
+	// Hooks are WebAssembly modules that may classify or veto tokens before
+	// or after the regex tables, for rules that patterns cannot express.
+	Hooks []HookConfig `yaml:"hooks,omitempty"`
 }
 
 // CompiledSurroundRegexp holds a compiled surround regex configuration
@@ -69,52 +182,183 @@ type CompiledSurroundRegexp struct {
 // StartTokenInfo holds information about a start token including its serial number and endings
 type StartTokenInfo struct {
 	SerialNumber int             // Serial number for this start/end/endings group
+	Pattern      string          // The start pattern
 	Endings      map[string]bool // End substitution patterns
 }
 
-// CompiledClassifierConfig holds compiled RegexpTable patterns
+// CompiledClassifierConfig holds compiled RegexpTable patterns. It is
+// immutable once CompileRegexes has returned, so a single compiled config can
+// be shared by any number of classifier engines across goroutines. The
+// start and end token tables depend on the tokens being classified and so
+// belong to the classifier engine instead.
 type CompiledClassifierConfig struct {
-	// New efficient start token recognizer - maps start patterns to start token info
-	StartTokenTable *regexptable.RegexpTable[*StartTokenInfo] // For quick lookup of serial number and end substitutions
-	EndTokenTable   *regexptable.RegexpTable[bool]            // For quick lookup of end tokens mapping to serial numbers
-
 	OpenBracketTable     map[string]*BracketPairsConfig
 	CloseBracketSetAsMap map[string]bool
 
-	// All patterns now use RegexpTables for performance
-	FormPrefixRegexpTable    *regexptable.RegexpTable[bool]
-	SimpleLabelRegexpTable   *regexptable.RegexpTable[bool]
-	CompoundLabelRegexpTable *regexptable.RegexpTable[bool]
-	VariableRegexpTable      *regexptable.RegexpTable[bool]
+	// All patterns now use RegexpTables for performance. The simple tables
+	// map each pattern to itself so that matches can be explained.
+	FormPrefixRegexpTable    *regexptable.RegexpTable[string]
+	SimpleLabelRegexpTable   *regexptable.RegexpTable[string]
+	CompoundLabelRegexpTable *regexptable.RegexpTable[string]
+	VariableRegexpTable      *regexptable.RegexpTable[string]
+	CommentRegexpTable       *regexptable.RegexpTable[string]
+	StringRegexpTable        *regexptable.RegexpTable[string]
+	NumberRegexpTable        *regexptable.RegexpTable[string]
 	OperatorRegexpTable      *regexptable.RegexpTable[CompiledOperatorConfig]
+
+	// NormalizeToken converts a token into the configured Unicode normal
+	// form and folds its case. It is nil when neither is configured.
+	NormalizeToken func(string) string
+
+	// Tokens says how the lines of the input become tokens.
+	Tokens TokenPolicy
+
+	// Words maps a section name onto the words of its words file. It is nil
+	// when no section has a words file.
+	Words map[string]*CompiledWords
+
+	// ExceptTables maps a section name onto the patterns that veto its
+	// matches.
+	ExceptTables map[string]*regexptable.RegexpTable[string]
+
+	// Priorities maps a section name and pattern onto its priority. It is
+	// nil when no priorities are configured.
+	Priorities map[string]map[string]int
+
+	// MergedTable holds the patterns of all the sections above, tried in
+	// lookup order, so that a token needs a single lookup. It is nil when
+	// priorities or except patterns mean that the first section to match
+	// does not necessarily win, or when there are words files.
+	MergedTable *regexptable.RegexpTable[MergedEntry]
+
+	// OutputCodes maps classification codes onto the names written in their
+	// place. It is nil when no codes are renamed.
+	OutputCodes map[string]string
+
+	// ContextRules choose between the classes of a token by the class of
+	// the token before it, in order; the first that applies wins.
+	ContextRules []CompiledContextRule
+
+	// Hooks are consulted in order, the before hooks ahead of the regex
+	// tables and the after hooks behind them.
+	Hooks []CompiledHook
+
+	// RegexpEngine compiles the patterns of the tables, including those
+	// built from the input. It is nil for the standard RE2 engine.
+	RegexpEngine regexptable.RegexpEngine
+}
+
+// MergedEntry identifies the section and pattern behind a match in the
+// merged table.
+type MergedEntry struct {
+	Section  string
+	Pattern  string
+	Operator *CompiledOperatorConfig // The operator, for operator-regexp
+}
+
+// mergedSections lists the sections of the merged table in lookup order. The
+// form tables, which depend on the input, come between the form prefixes and
+// the operators.
+var mergedSections = []string{
+	"comment-regexp",
+	"string-regexp",
+	"number-regexp",
+	"compound-label-regexp",
+	"simple-label-regexp",
+	"form-prefix-regexp",
+	"operator-regexp",
+	"variable-regexp",
 }
 
 // CompiledOperatorConfig holds a compiled operator configuration
 type CompiledOperatorConfig struct {
+	Pattern     string
 	PrefixPrec  uint16
 	InfixPrec   uint16
 	PostfixPrec uint16
 	EndTokens   []string
+	// SerialNumber pairs a form-starting operator with its end tokens. The
+	// operators are numbered after the surround groups.
+	SerialNumber int
 }
 
-// LoadClassifierConfig loads configuration from a YAML file
+// LoadClassifierConfig loads configuration from a YAML file, stdin or a URL
+// (see ReadSource)
 func LoadClassifierConfig(filename string) (*ClassifierConfig, error) {
-	data, err := os.ReadFile(filename) // #nosec G304, this is a CLI application.
+	data, err := ReadSource(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file %s: %w", filename, err)
 	}
 
-	var config ClassifierConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	config, _, err := parseClassifierConfig(data, filename)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse config file %s: %w", filename, err)
 	}
 
-	return &config, nil
+	return config.ResolveExtends(filename)
+}
+
+// ParseClassifierConfig parses configuration from YAML text. Old keys, see
+// KeyAliases, are read as the keys that replaced them, with a warning.
+func ParseClassifierConfig(data []byte) (*ClassifierConfig, error) {
+	config, _, err := parseClassifierConfig(data, "")
+	return config, err
+}
+
+// parseClassifierConfig implements ParseClassifierConfig for a config read
+// from name, which the warnings give, reporting whether it has old keys.
+func parseClassifierConfig(data []byte, name string) (*ClassifierConfig, bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+	deprecated := false
+	warn := warnAlias(name)
+	err := renameAliases(&doc, func(alias KeyAlias, key string, line int) {
+		deprecated = true
+		warn(alias, key, line)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	var config ClassifierConfig
+	if err := doc.Decode(&config); err != nil {
+		return nil, false, err
+	}
+
+	if config.BuiltinNumbers {
+		config.NumberRegexp = appendNew(config.NumberRegexp, DefaultNumberRegexp)
+	}
+
+	return &config, deprecated, nil
 }
 
 // CompileRegexes compiles static regex patterns in the configuration using RegexpTables
-// Note: StartTokenTable and EndTokenTable are built dynamically during token analysis
+// Note: the start and end token tables are built dynamically during token analysis
+// If Unicode normalization is configured then the patterns are normalized in place.
 func (cc *ClassifierConfig) CompileRegexes() (*CompiledClassifierConfig, error) {
+	return cc.CompileRegexesWith(nil)
+}
+
+// CompileRegexesWith is like CompileRegexes but passes the diagnostics, such
+// as the empty patterns that are skipped, to ds.
+func (cc *ClassifierConfig) CompileRegexesWith(ds Diagnostics) (*CompiledClassifierConfig, error) {
+	if cc.Extends != "" {
+		return nil, fmt.Errorf("extends: %s has not been resolved, see ResolveExtends", cc.Extends)
+	}
+	form, err := cc.NormalizationForm()
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		cc.normalizePatterns(*form)
+	}
+	// The engine is needed to count the capture groups of the patterns.
+	engine, err := cc.regexpEngine(ds)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate surround-regexp configurations
 	for i, surroundConfig := range cc.SurroundRegexp {
 		// Ensure that at least one of 'endings' or 'end' is present
@@ -122,6 +366,21 @@ func (cc *ClassifierConfig) CompileRegexes() (*CompiledClassifierConfig, error)
 			return nil, fmt.Errorf("surround-regexp[%d] must have either 'endings' array or 'end' pattern (or both)", i)
 		}
 
+		for j, start := range surroundConfig.Starts {
+			if start == "" {
+				return nil, fmt.Errorf("surround-regexp[%d].starts[%d] must not be empty", i, j)
+			}
+		}
+
+		// Every $N must refer to a capture group of each of the starts.
+		where := fmt.Sprintf("surround-regexp[%d]", i)
+		if err := checkSubstitutions(engine, where+".endings", surroundConfig.StartPatterns(), surroundConfig.Endings); err != nil {
+			return nil, err
+		}
+		if err := checkSubstitutions(engine, where+".intermediates", surroundConfig.StartPatterns(), surroundConfig.Intermediates); err != nil {
+			return nil, err
+		}
+
 		// Check for invalid backreference usage in endings when end is missing
 		if len(surroundConfig.Endings) > 0 && surroundConfig.End == "" {
 			for j, ending := range surroundConfig.Endings {
@@ -132,80 +391,224 @@ func (cc *ClassifierConfig) CompileRegexes() (*CompiledClassifierConfig, error)
 		}
 	}
 
-	compiled := &CompiledClassifierConfig{}
-	var err error
+	for i, opConfig := range cc.OperatorRegexp {
+		if err := checkSubstitutions(engine, fmt.Sprintf("operator-regexp[%d].end-tokens", i), []string{opConfig.Pattern}, opConfig.EndTokens); err != nil {
+			return nil, err
+		}
+	}
+
+	fold, err := cc.caseFolding()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := cc.TokenPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &CompiledClassifierConfig{Tokens: policy}
+	switch {
+	case form != nil && fold != nil:
+		compiled.NormalizeToken = func(token string) string { return fold(form.String(token)) }
+	case form != nil:
+		compiled.NormalizeToken = form.String
+	case fold != nil:
+		compiled.NormalizeToken = fold
+	}
+
+	if err := cc.checkPriorities(); err != nil {
+		return nil, err
+	}
+	if err := cc.checkMatchStrategy(); err != nil {
+		return nil, err
+	}
+	compiled.RegexpEngine = engine
+	if err := cc.checkOutputCodes(); err != nil {
+		return nil, err
+	}
+	if len(cc.OutputCodes) > 0 {
+		compiled.OutputCodes = maps.Clone(cc.OutputCodes)
+	}
+	if compiled.ContextRules, err = cc.compileContextRules(compiled.RegexpEngine); err != nil {
+		return nil, err
+	}
+	if compiled.Hooks, err = cc.compileHooks(); err != nil {
+		return nil, err
+	}
+	if compiled.Words, err = cc.compileWords(compiled.NormalizeToken); err != nil {
+		return nil, err
+	}
+	for section, priorities := range cc.Priority {
+		if compiled.Priorities == nil {
+			compiled.Priorities = make(map[string]map[string]int)
+		}
+		compiled.Priorities[section] = maps.Clone(priorities)
+	}
+
+	// Build the except tables
+	for _, section := range slices.Sorted(maps.Keys(cc.Except)) {
+		if !slices.Contains(PatternSections, section) {
+			return nil, fmt.Errorf("except: unknown section %q (expected one of %s)", section, strings.Join(PatternSections, ", "))
+		}
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.Except[section] {
+			if pattern != "" {
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("except." + section))
+			}
+		}
+		table, err := builder.Build(true, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build except table for %s: %w", section, err)
+		}
+		if compiled.ExceptTables == nil {
+			compiled.ExceptTables = make(map[string]*regexptable.RegexpTable[string])
+		}
+		compiled.ExceptTables[section] = table
+		slog.Debug("built table", "section", "except."+section, "patterns", len(cc.Except[section]))
+	}
 
-	// NOTE: StartTokenTable and EndTokenTable are NOT built here
+	// NOTE: The start and end token tables are NOT built here
 	// They are built dynamically in BuildFormStartEndMappings based on actual input tokens
 
+	// Build comment-regexp table
+	if len(cc.CommentRegexp) > 0 {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("comment-regexp", cc.CommentRegexp) {
+			if pattern != "" {
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("comment-regexp"))
+			}
+		}
+		compiled.CommentRegexpTable, err = builder.Build(true, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build comment-regexp table: %w", err)
+		}
+		slog.Debug("built table", "section", "comment-regexp", "patterns", len(cc.CommentRegexp))
+	}
+
+	// Build string-regexp table
+	if len(cc.StringRegexp) > 0 {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("string-regexp", cc.StringRegexp) {
+			if pattern != "" {
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("string-regexp"))
+			}
+		}
+		compiled.StringRegexpTable, err = builder.Build(true, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build string-regexp table: %w", err)
+		}
+		slog.Debug("built table", "section", "string-regexp", "patterns", len(cc.StringRegexp))
+	}
+
+	// Build number-regexp table
+	if len(cc.NumberRegexp) > 0 {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("number-regexp", cc.NumberRegexp) {
+			if pattern != "" {
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("number-regexp"))
+			}
+		}
+		compiled.NumberRegexpTable, err = builder.Build(true, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build number-regexp table: %w", err)
+		}
+		slog.Debug("built table", "section", "number-regexp", "patterns", len(cc.NumberRegexp))
+	}
+
 	// Build form-prefix-regexp table
 	if len(cc.FormPrefixRegexp) > 0 {
-		builder := regexptable.NewRegexpTableBuilder[bool]()
-		for _, pattern := range cc.FormPrefixRegexp {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("form-prefix-regexp", cc.FormPrefixRegexp) {
 			if pattern != "" {
-				builder.AddPattern(pattern, true)
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("form-prefix-regexp"))
 			}
 		}
 		compiled.FormPrefixRegexpTable, err = builder.Build(true, true)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build form-prefix-regexp table: %w", err)
 		}
+		slog.Debug("built table", "section", "form-prefix-regexp", "patterns", len(cc.FormPrefixRegexp))
 	}
 
 	// Build simple-label-regexp table
 	if len(cc.SimpleLabelRegexp) > 0 {
-		builder := regexptable.NewRegexpTableBuilder[bool]()
-		for _, pattern := range cc.SimpleLabelRegexp {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("simple-label-regexp", cc.SimpleLabelRegexp) {
 			if pattern != "" {
-				builder.AddPattern(pattern, true)
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("simple-label-regexp"))
 			}
 		}
 		compiled.SimpleLabelRegexpTable, err = builder.Build(true, true)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build simple-label-regexp table: %w", err)
 		}
+		slog.Debug("built table", "section", "simple-label-regexp", "patterns", len(cc.SimpleLabelRegexp))
 	}
 
 	// Build compound-label-regexp table
 	if len(cc.CompoundLabelRegexp) > 0 {
-		builder := regexptable.NewRegexpTableBuilder[bool]()
-		for _, pattern := range cc.CompoundLabelRegexp {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("compound-label-regexp", cc.CompoundLabelRegexp) {
 			if pattern != "" {
-				builder.AddPattern(pattern, true)
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("compound-label-regexp"))
 			}
 		}
 		compiled.CompoundLabelRegexpTable, err = builder.Build(true, true)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build compound-label-regexp table: %w", err)
 		}
+		slog.Debug("built table", "section", "compound-label-regexp", "patterns", len(cc.CompoundLabelRegexp))
 	}
 
 	// Build variable-regexp table
 	if len(cc.VariableRegexp) > 0 {
-		builder := regexptable.NewRegexpTableBuilder[bool]()
-		for _, pattern := range cc.VariableRegexp {
+		builder := NewTableBuilder[string](compiled.RegexpEngine)
+		for _, pattern := range cc.byPriority("variable-regexp", cc.VariableRegexp) {
 			if pattern != "" {
-				builder.AddPattern(pattern, true)
+				builder.AddPattern(pattern, pattern)
+			} else {
+				ds.Report(skippedEmpty("variable-regexp"))
 			}
 		}
 		compiled.VariableRegexpTable, err = builder.Build(true, true)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build variable-regexp table: %w", err)
 		}
+		slog.Debug("built table", "section", "variable-regexp", "patterns", len(cc.VariableRegexp))
 	}
 
 	// Build operator-regexp table
+	var operators []CompiledOperatorConfig
 	if len(cc.OperatorRegexp) > 0 {
-		builder := regexptable.NewRegexpTableBuilder[CompiledOperatorConfig]()
-		for i, opConfig := range cc.OperatorRegexp {
+		builder := NewTableBuilder[CompiledOperatorConfig](compiled.RegexpEngine)
+		for _, i := range cc.priorityOrder("operator-regexp", cc.SectionPatterns("operator-regexp")) {
+			opConfig := cc.OperatorRegexp[i]
 			if opConfig.Pattern != "" {
 				compiledOp := CompiledOperatorConfig{
+					Pattern:     opConfig.Pattern,
 					PrefixPrec:  opConfig.PrefixPrec,
 					InfixPrec:   opConfig.InfixPrec,
 					PostfixPrec: opConfig.PostfixPrec,
 					EndTokens:   opConfig.EndTokens,
+
+					SerialNumber: len(cc.SurroundRegexp) + i,
 				}
 				builder.AddPattern(opConfig.Pattern, compiledOp)
+				operators = append(operators, compiledOp)
 			} else {
 				return nil, fmt.Errorf("operator-regexp pattern %d is empty", i)
 			}
@@ -214,6 +617,7 @@ func (cc *ClassifierConfig) CompileRegexes() (*CompiledClassifierConfig, error)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build operator-regexp table: %w", err)
 		}
+		slog.Debug("built table", "section", "operator-regexp", "patterns", len(cc.OperatorRegexp))
 	}
 
 	if len(cc.BracketPairs) > 0 {
@@ -227,11 +631,106 @@ func (cc *ClassifierConfig) CompileRegexes() (*CompiledClassifierConfig, error)
 				return nil, fmt.Errorf("bracket-regexp start pattern %d is empty", i)
 			}
 		}
+		slog.Debug("built table", "section", "bracket-pairs", "patterns", len(cc.BracketPairs))
+	}
+
+	// Without priorities, except patterns or words the first section to
+	// match wins, which is what a single table of all the sections in order
+	// gives.
+	if compiled.Priorities == nil && compiled.ExceptTables == nil && compiled.Words == nil {
+		compiled.MergedTable, err = cc.buildMergedTable(compiled.RegexpEngine, operators)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return compiled, nil
 }
 
+// buildMergedTable builds the merged table from the sections in lookup order,
+// given the operators in table order. It returns nil if there are no
+// patterns.
+func (cc *ClassifierConfig) buildMergedTable(engine regexptable.RegexpEngine, operators []CompiledOperatorConfig) (*regexptable.RegexpTable[MergedEntry], error) {
+	builder := NewTableBuilder[MergedEntry](engine)
+	n := 0
+	for _, section := range mergedSections {
+		if section == "operator-regexp" {
+			for i := range operators {
+				builder.AddPattern(operators[i].Pattern, MergedEntry{Section: section, Pattern: operators[i].Pattern, Operator: &operators[i]})
+				n++
+			}
+			continue
+		}
+		for _, pattern := range cc.byPriority(section, cc.SectionPatterns(section)) {
+			if pattern != "" {
+				builder.AddPattern(pattern, MergedEntry{Section: section, Pattern: pattern})
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	table, err := builder.Build(true, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build merged table: %w", err)
+	}
+	slog.Debug("built table", "section", "merged", "patterns", n)
+	return table, nil
+}
+
+// skippedEmpty is the diagnostic of an empty pattern, which is skipped
+// because it cannot match a token.
+func skippedEmpty(section string) Diagnostic {
+	return Diagnostic{Level: slog.LevelWarn, Message: "skipped empty pattern", Section: section, Group: -1}
+}
+
+// checkSubstitutions checks that the substitutions listed under a key refer
+// only to capture groups that every one of the start patterns has. Invalid
+// start patterns are left for the tables to report.
+func checkSubstitutions(engine regexptable.RegexpEngine, key string, starts []string, substitutions []string) error {
+	for j, substitution := range substitutions {
+		for _, n := range substitutionGroups(substitution) {
+			for _, start := range starts {
+				re, err := compilePattern(engine, start)
+				if err != nil {
+					continue
+				}
+				subexps := len(re.SubexpNames()) - 1
+				if n <= subexps {
+					continue
+				}
+				groups := fmt.Sprintf("only %d capture groups", subexps)
+				switch subexps {
+				case 0:
+					groups = "no capture groups"
+				case 1:
+					groups = "only 1 capture group"
+				}
+				return fmt.Errorf("%s[%d] %q refers to $%d but the pattern %q has %s", key, j, substitution, n, start, groups)
+			}
+		}
+	}
+	return nil
+}
+
+// substitutionGroups returns the numbers of the capture groups that a
+// substitution refers to, other than $0, as SubstitutePattern reads it.
+func substitutionGroups(pattern string) []int {
+	var groups []int
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] != '$' {
+			continue
+		}
+		next := pattern[i+1]
+		if next >= '1' && next <= '9' {
+			groups = append(groups, int(next-'0'))
+		}
+		i++ // Skip the digit, or the second $ of $$
+	}
+	return groups
+}
+
 // SubstitutePattern performs substitution using capture groups
 // groups[0] is the full match ($0), groups[1] is first capture group ($1), etc.
 // Also handles $$ as an escape sequence for literal $
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sfkleach/regexptable"
+)
+
+// StartOfInput stands for the absence of a previous token in the after list
+// of a context rule.
+const StartOfInput = "^"
+
+// ContextRuleConfig chooses the classification of the tokens matching a
+// pattern by the class of the token before them, e.g. - is a form-prefix
+// after an operator and an operator after a variable.
+type ContextRuleConfig struct {
+	Pattern string   `yaml:"pattern"`
+	After   []string `yaml:"after"` // Codes of the previous token, or ^ for none
+	Class   string   `yaml:"class"`
+}
+
+// CompiledContextRule holds a compiled context rule.
+type CompiledContextRule struct {
+	Pattern regexptable.CompiledRegexp
+	After   map[string]bool
+	Class   string
+}
+
+// compileContextRules validates and compiles the context-rules section with
+// the engine of the config.
+// Classes are given by their codes, as written before output-codes renames
+// them.
+func (cc *ClassifierConfig) compileContextRules(engine regexptable.RegexpEngine) ([]CompiledContextRule, error) {
+	var rules []CompiledContextRule
+	for i, rule := range cc.ContextRules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("context-rules[%d] must have a pattern", i)
+		}
+		re, err := compilePattern(engine, rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("context-rules[%d]: invalid pattern %q: %w", i, rule.Pattern, err)
+		}
+		if !slices.Contains(ClassCodes, rule.Class) || rule.Class == "U" {
+			return nil, fmt.Errorf("context-rules[%d]: unknown class %q (expected one of %s)", i, rule.Class, strings.Join(ClassCodes[:len(ClassCodes)-1], " "))
+		}
+		if len(rule.After) == 0 {
+			return nil, fmt.Errorf("context-rules[%d] must list the classes it applies after", i)
+		}
+		after := make(map[string]bool, len(rule.After))
+		for _, code := range rule.After {
+			if code != StartOfInput && !slices.Contains(ClassCodes, code) {
+				return nil, fmt.Errorf("context-rules[%d]: unknown class %q in after (expected one of %s or %s)", i, code, strings.Join(ClassCodes, " "), StartOfInput)
+			}
+			after[code] = true
+		}
+		rules = append(rules, CompiledContextRule{Pattern: re, After: after, Class: rule.Class})
+	}
+	return rules, nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Diagnostic is a finding that is not an error, made while compiling a
+// config or building the form mappings from the tokens, such as a pattern
+// that was skipped or an ending that was inferred from a token.
+type Diagnostic struct {
+	Level   slog.Level // slog.LevelWarn for likely mistakes, slog.LevelDebug for the workings of inference
+	Message string
+	Section string // The config section concerned, if any
+	Group   int    // The surround group or form-starting operator concerned, otherwise -1
+	Token   string // The token concerned, if any
+	Pattern string // The pattern concerned, if any
+}
+
+// Log logs the diagnostic with slog at its level.
+func (d Diagnostic) Log() {
+	var attrs []slog.Attr
+	if d.Section != "" {
+		attrs = append(attrs, slog.String("section", d.Section))
+	}
+	if d.Group >= 0 {
+		attrs = append(attrs, slog.Int("group", d.Group))
+	}
+	if d.Token != "" {
+		attrs = append(attrs, slog.String("token", d.Token))
+	}
+	if d.Pattern != "" {
+		attrs = append(attrs, slog.String("pattern", d.Pattern))
+	}
+	slog.LogAttrs(context.Background(), d.Level, d.Message, attrs...)
+}
+
+// Diagnostics receives the diagnostics, so that embedders can display them
+// as they see fit. A nil Diagnostics logs them with slog instead.
+type Diagnostics func(Diagnostic)
+
+// Report passes the diagnostic on, or logs it if ds is nil.
+func (ds Diagnostics) Report(d Diagnostic) {
+	if ds == nil {
+		d.Log()
+		return
+	}
+	ds(d)
+}
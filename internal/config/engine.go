@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/pcre"
+	"github.com/sfkleach/regexptable"
+)
+
+// The values of regex-engine.
+const (
+	EngineRE2  = "re2"  // Go's regexp package, which matches in linear time
+	EnginePCRE = "pcre" // A backtracking engine with backreferences and lookarounds
+)
+
+// regexpEngine returns the engine that the regex-engine setting selects, or
+// nil for the standard RE2 engine. Selecting pcre is reported to ds.
+func (cc *ClassifierConfig) regexpEngine(ds Diagnostics) (regexptable.RegexpEngine, error) {
+	switch strings.ToLower(cc.RegexEngine) {
+	case "", EngineRE2:
+		return nil, nil
+	case EnginePCRE:
+		ds.Report(Diagnostic{Level: slog.LevelWarn, Message: "regex-engine pcre matches by backtracking, which is slower than re2 and can take exponential time on some patterns", Group: -1})
+		return pcre.Engine{}, nil
+	}
+	return nil, fmt.Errorf("unknown regex-engine %q (expected %s or %s)", cc.RegexEngine, EngineRE2, EnginePCRE)
+}
+
+// NewTableBuilder returns a builder for a table whose patterns are compiled
+// by the engine, or by the standard RE2 engine if it is nil.
+func NewTableBuilder[T any](engine regexptable.RegexpEngine) *regexptable.RegexpTableBuilder[T] {
+	if engine == nil {
+		return regexptable.NewRegexpTableBuilder[T]()
+	}
+	return regexptable.NewRegexpTableBuilderWithEngine[T](engine)
+}
+
+// CompilePattern compiles a pattern that must match the whole of a token,
+// with the engine of the config.
+func (c *CompiledClassifierConfig) CompilePattern(pattern string) (regexptable.CompiledRegexp, error) {
+	return compilePattern(c.RegexpEngine, pattern)
+}
+
+// compilePattern compiles a pattern that must match the whole of a token
+// with the engine, or the standard RE2 engine if it is nil.
+func compilePattern(engine regexptable.RegexpEngine, pattern string) (regexptable.CompiledRegexp, error) {
+	if engine == nil {
+		engine = regexptable.NewStandardRegexpEngine()
+	}
+	return engine.Compile("^(?:" + pattern + ")$")
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/url"
+	"path/filepath"
+	"slices"
+)
+
+// ResolveExtends returns the config with the config that it extends, and so
+// on, merged in. The name is where the config was read from, see ReadSource;
+// a relative extends is resolved against it. Configs that extend nothing are
+// returned as they are, except that their hook modules and words files are
+// resolved against the name in the same way.
+//
+// The derived config is merged into its base section by section. Lists of
+// patterns are appended to the base's, without duplicates. Surround groups,
+// operators and bracket pairs with the same pattern as one in the base
+// replace it in place, and the rest are appended. In except, priority and
+// output-codes the derived config wins, and its context rules are tried
+// before the base's. Settings such as match-strategy are inherited unless
+// they are given.
+func (cc *ClassifierConfig) ResolveExtends(name string) (*ClassifierConfig, error) {
+	return cc.resolveExtends(name, nil)
+}
+
+// resolveExtends implements ResolveExtends, where chain lists the configs
+// that extend this one, to detect cycles.
+func (cc *ClassifierConfig) resolveExtends(name string, chain []string) (*ClassifierConfig, error) {
+	cc = cc.withSources(name)
+	if cc.Extends == "" {
+		return cc, nil
+	}
+	baseName := extendsSource(name, cc.Extends)
+	chain = append(chain, name)
+	if slices.Contains(chain, baseName) {
+		return nil, fmt.Errorf("extends: %s is part of a cycle", baseName)
+	}
+	data, err := ReadSource(baseName)
+	if err != nil {
+		return nil, fmt.Errorf("extends: failed to read config file %s: %w", baseName, err)
+	}
+	base, _, err := parseClassifierConfig(data, baseName)
+	if err != nil {
+		return nil, fmt.Errorf("extends: failed to parse config file %s: %w", baseName, err)
+	}
+	if base, err = base.resolveExtends(baseName, chain); err != nil {
+		return nil, err
+	}
+	return base.extendedBy(cc), nil
+}
+
+// withSources returns the config with its hook modules and words files
+// resolved against name, where the config was read from, as extends is. The
+// config is returned as it is if it has neither.
+func (cc *ClassifierConfig) withSources(name string) *ClassifierConfig {
+	if len(cc.Hooks) == 0 && len(cc.WordsFiles()) == 0 {
+		return cc
+	}
+	resolved := *cc
+	resolved.Hooks = make([]HookConfig, len(cc.Hooks))
+	for i, hook := range cc.Hooks {
+		if hook.Wasm != "" {
+			hook.Wasm = extendsSource(name, hook.Wasm)
+		}
+		resolved.Hooks[i] = hook
+	}
+	for _, file := range []*string{&resolved.FormPrefixWordsFile, &resolved.SimpleLabelWordsFile, &resolved.CompoundLabelWordsFile, &resolved.VariableWordsFile} {
+		if *file != "" {
+			*file = extendsSource(name, *file)
+		}
+	}
+	return &resolved
+}
+
+// extendsSource returns the source of the config that the config read from
+// name extends.
+func extendsSource(name, extends string) string {
+	switch {
+	case IsURL(extends) || filepath.IsAbs(extends):
+		return extends
+	case IsURL(name):
+		u, err := url.Parse(name)
+		if err != nil {
+			return extends
+		}
+		ref, err := url.Parse(extends)
+		if err != nil {
+			return extends
+		}
+		return u.ResolveReference(ref).String()
+	case name == StdinSource || name == "":
+		return extends
+	default:
+		return filepath.Join(filepath.Dir(name), extends)
+	}
+}
+
+// extendedBy returns a new config in which the derived config is merged
+// into this one.
+func (cc *ClassifierConfig) extendedBy(derived *ClassifierConfig) *ClassifierConfig {
+	merged := &ClassifierConfig{
+		SurroundRegexp:       mergeBy(cc.SurroundRegexp, derived.SurroundRegexp, SurroundRegexpConfig.StartPattern),
+		FormPrefixRegexp:     appendNew(cc.FormPrefixRegexp, derived.FormPrefixRegexp),
+		SimpleLabelRegexp:    appendNew(cc.SimpleLabelRegexp, derived.SimpleLabelRegexp),
+		CompoundLabelRegexp:  appendNew(cc.CompoundLabelRegexp, derived.CompoundLabelRegexp),
+		VariableRegexp:       appendNew(cc.VariableRegexp, derived.VariableRegexp),
+		CommentRegexp:        appendNew(cc.CommentRegexp, derived.CommentRegexp),
+		StringRegexp:         appendNew(cc.StringRegexp, derived.StringRegexp),
+		BuiltinNumbers:       cc.BuiltinNumbers || derived.BuiltinNumbers,
+		NumberRegexp:         appendNew(cc.NumberRegexp, derived.NumberRegexp),
+		BracketPairs:         mergeBy(cc.BracketPairs, derived.BracketPairs, func(b BracketPairsConfig) string { return b.Open }),
+		OperatorRegexp:       mergeBy(cc.OperatorRegexp, derived.OperatorRegexp, func(op OperatorConfig) string { return op.Pattern }),
+		MatchStrategy:        cmp.Or(derived.MatchStrategy, cc.MatchStrategy),
+		RegexEngine:          cmp.Or(derived.RegexEngine, cc.RegexEngine),
+		UnicodeNormalization: cmp.Or(derived.UnicodeNormalization, cc.UnicodeNormalization),
+		TokenWhitespace:      cmp.Or(derived.TokenWhitespace, cc.TokenWhitespace),
+		BlankTokens:          cmp.Or(derived.BlankTokens, cc.BlankTokens),
+		InvalidUTF8:          cmp.Or(derived.InvalidUTF8, cc.InvalidUTF8),
+		CaseFolding:          cmp.Or(derived.CaseFolding, cc.CaseFolding),
+		OutputCodes:          mergeMaps(cc.OutputCodes, derived.OutputCodes),
+		ContextRules:         slices.Concat(derived.ContextRules, cc.ContextRules),
+		Hooks:                slices.Concat(derived.Hooks, cc.Hooks),
+
+		FormPrefixWordsFile:    cmp.Or(derived.FormPrefixWordsFile, cc.FormPrefixWordsFile),
+		SimpleLabelWordsFile:   cmp.Or(derived.SimpleLabelWordsFile, cc.SimpleLabelWordsFile),
+		CompoundLabelWordsFile: cmp.Or(derived.CompoundLabelWordsFile, cc.CompoundLabelWordsFile),
+		VariableWordsFile:      cmp.Or(derived.VariableWordsFile, cc.VariableWordsFile),
+	}
+	for section := range mergeMaps(cc.Except, derived.Except) {
+		if merged.Except == nil {
+			merged.Except = make(map[string][]string)
+		}
+		merged.Except[section] = appendNew(cc.Except[section], derived.Except[section])
+	}
+	for section := range mergeMaps(cc.Priority, derived.Priority) {
+		if merged.Priority == nil {
+			merged.Priority = make(map[string]map[string]int)
+		}
+		merged.Priority[section] = mergeMaps(cc.Priority[section], derived.Priority[section])
+	}
+	return merged
+}
+
+// appendNew appends the patterns that the base does not already have.
+func appendNew(base, derived []string) []string {
+	merged := slices.Clone(base)
+	for _, pattern := range derived {
+		if !slices.Contains(merged, pattern) {
+			merged = append(merged, pattern)
+		}
+	}
+	return merged
+}
+
+// mergeBy replaces the entries of the base with the derived entries that
+// have the same key, and appends the rest.
+func mergeBy[T any](base, derived []T, key func(T) string) []T {
+	merged := slices.Clone(base)
+	for _, entry := range derived {
+		if i := slices.IndexFunc(merged, func(e T) bool { return key(e) == key(entry) }); i >= 0 {
+			merged[i] = entry
+		} else {
+			merged = append(merged, entry)
+		}
+	}
+	return merged
+}
+
+// mergeMaps returns the union of two maps, the derived entries winning.
+func mergeMaps[V any](base, derived map[string]V) map[string]V {
+	if base == nil && derived == nil {
+		return nil
+	}
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]V, len(derived))
+	}
+	maps.Copy(merged, derived)
+	return merged
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fingerprint identifies the settings of the config as "sha256:<hex>". It is
+// computed from the parsed config rather than the file, so comments and
+// formatting do not affect it.
+func (cc *ClassifierConfig) Fingerprint() (string, error) {
+	data, err := yaml.Marshal(cc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/hooks"
+)
+
+// The values of when in a hook.
+const (
+	HookBefore = "before" // The hook runs before the regex tables
+	HookAfter  = "after"  // The hook runs after the regex tables
+)
+
+// HookConfig names a WebAssembly module that gets a chance to classify or
+// veto each token, see package hooks.
+type HookConfig struct {
+	Wasm string `yaml:"wasm"`           // Where the module is read from, see ReadSource
+	When string `yaml:"when,omitempty"` // before or after (the default) the regex tables
+}
+
+// CompiledHook holds a compiled hook module.
+type CompiledHook struct {
+	Before bool
+	Module *hooks.Module
+}
+
+// compileHooks reads and compiles the modules of the hooks section.
+func (cc *ClassifierConfig) compileHooks() ([]CompiledHook, error) {
+	var compiled []CompiledHook
+	for i, hook := range cc.Hooks {
+		if hook.Wasm == "" {
+			return nil, fmt.Errorf("hooks[%d] must have a wasm module", i)
+		}
+		var before bool
+		switch strings.ToLower(hook.When) {
+		case "", HookAfter:
+		case HookBefore:
+			before = true
+		default:
+			return nil, fmt.Errorf("hooks[%d]: unknown when %q (expected %s or %s)", i, hook.When, HookBefore, HookAfter)
+		}
+		wasm, err := ReadSource(hook.Wasm)
+		if err != nil {
+			return nil, fmt.Errorf("hooks[%d]: failed to read %s: %w", i, hook.Wasm, err)
+		}
+		module, err := hooks.Compile(hook.Wasm, wasm)
+		if err != nil {
+			return nil, fmt.Errorf("hooks[%d]: %w", i, err)
+		}
+		compiled = append(compiled, CompiledHook{Before: before, Module: module})
+	}
+	return compiled, nil
+}
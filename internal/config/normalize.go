@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizationForms maps the accepted names of Unicode normal forms.
+var normalizationForms = map[string]norm.Form{
+	"nfc":  norm.NFC,
+	"nfd":  norm.NFD,
+	"nfkc": norm.NFKC,
+	"nfkd": norm.NFKD,
+}
+
+// NormalizationForm returns the Unicode normal form selected by the
+// unicode-normalization setting, or nil if tokens are matched as they are.
+func (cc *ClassifierConfig) NormalizationForm() (*norm.Form, error) {
+	name := strings.ToLower(cc.UnicodeNormalization)
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	form, ok := normalizationForms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown unicode-normalization %q (expected none, nfc, nfd, nfkc or nfkd)", cc.UnicodeNormalization)
+	}
+	return &form, nil
+}
+
+// normalizePatterns rewrites every pattern and ending into the given normal
+// form, so that patterns match tokens that have been normalized the same way.
+// Normalization is idempotent, so this is safe to repeat.
+func (cc *ClassifierConfig) normalizePatterns(form norm.Form) {
+	normalizeAll := func(patterns []string) {
+		for i, pattern := range patterns {
+			patterns[i] = form.String(pattern)
+		}
+	}
+
+	for i := range cc.SurroundRegexp {
+		s := &cc.SurroundRegexp[i]
+		s.Start = form.String(s.Start)
+		normalizeAll(s.Starts)
+		s.End = form.String(s.End)
+		normalizeAll(s.Endings)
+		normalizeAll(s.Intermediates)
+	}
+	normalizeAll(cc.FormPrefixRegexp)
+	normalizeAll(cc.SimpleLabelRegexp)
+	normalizeAll(cc.CompoundLabelRegexp)
+	normalizeAll(cc.VariableRegexp)
+	normalizeAll(cc.CommentRegexp)
+	normalizeAll(cc.StringRegexp)
+	normalizeAll(cc.NumberRegexp)
+	for i := range cc.ContextRules {
+		cc.ContextRules[i].Pattern = form.String(cc.ContextRules[i].Pattern)
+	}
+	for _, patterns := range cc.Except {
+		normalizeAll(patterns)
+	}
+	for section, priorities := range cc.Priority {
+		normalized := make(map[string]int, len(priorities))
+		for pattern, priority := range priorities {
+			normalized[form.String(pattern)] = priority
+		}
+		cc.Priority[section] = normalized
+	}
+	for i := range cc.OperatorRegexp {
+		cc.OperatorRegexp[i].Pattern = form.String(cc.OperatorRegexp[i].Pattern)
+		normalizeAll(cc.OperatorRegexp[i].EndTokens)
+	}
+	for i := range cc.BracketPairs {
+		b := &cc.BracketPairs[i]
+		b.Open = form.String(b.Open)
+		b.Close = form.String(b.Close)
+	}
+}
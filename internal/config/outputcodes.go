@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ClassCodes lists the classification codes, which output-codes may rename.
+var ClassCodes = []string{"#", "Q", "N", "C", "L", "P", "S", "E", "I", "O", "V", "[", "]", "U"}
+
+// checkOutputCodes validates the output-codes section: every key must be a
+// classification code and every new name must be a single word, because the
+// fields of a line of the protocol are separated by spaces.
+func (cc *ClassifierConfig) checkOutputCodes() error {
+	for _, code := range slices.Sorted(maps.Keys(cc.OutputCodes)) {
+		if !slices.Contains(ClassCodes, code) {
+			return fmt.Errorf("output-codes: unknown code %q (expected one of %s)", code, strings.Join(ClassCodes, " "))
+		}
+		name := cc.OutputCodes[code]
+		if name == "" || strings.ContainsFunc(name, unicode.IsSpace) {
+			return fmt.Errorf("output-codes: %s must be renamed to a single word, not %q", code, name)
+		}
+	}
+	return nil
+}
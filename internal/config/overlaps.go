@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/sfkleach/re-classify/internal/gen"
+)
+
+// The kinds of Overlap.
+const (
+	// OverlapDuplicate is a pattern that appears twice in the config.
+	OverlapDuplicate = "duplicate"
+	// OverlapPrecedence is a pair of operators with different precedences
+	// that match the same token.
+	OverlapPrecedence = "precedence"
+)
+
+// overlapSamples is the number of tokens sampled from each operator pattern
+// when looking for the tokens that another operator also matches.
+const overlapSamples = 32
+
+// Overlap is a pair of patterns that match the same token, which the config
+// resolves silently by the order of its sections and patterns.
+type Overlap struct {
+	Kind   string
+	First  PatternRef
+	Second PatternRef
+	Token  string // A token that both match, for OverlapPrecedence
+}
+
+// PatternRef identifies a pattern by its section and position.
+type PatternRef struct {
+	Section string
+	Index   int
+	Pattern string
+}
+
+func (r PatternRef) String() string {
+	return fmt.Sprintf("%s[%d] %q", r.Section, r.Index, r.Pattern)
+}
+
+// Overlaps returns the patterns that appear more than once, across sections
+// or within one, and the operators with different precedences that match the
+// same token. Operators are compared by matching each against tokens sampled
+// from the other, so an overlap that no sample happens to hit is missed, as
+// are those of patterns that only the pcre engine understands.
+func (cc *ClassifierConfig) Overlaps() []Overlap {
+	var overlaps []Overlap
+	seen := make(map[string]PatternRef)
+	for _, section := range PatternSections {
+		for i, pattern := range cc.SectionPatterns(section) {
+			ref := PatternRef{Section: section, Index: i, Pattern: pattern}
+			if first, ok := seen[pattern]; ok {
+				overlaps = append(overlaps, Overlap{Kind: OverlapDuplicate, First: first, Second: ref})
+				continue
+			}
+			seen[pattern] = ref
+		}
+	}
+
+	operators := cc.OperatorRegexp
+	for i := range operators {
+		for j := i + 1; j < len(operators); j++ {
+			a, b := operators[i], operators[j]
+			if a.Pattern == b.Pattern || samePrecedences(a, b) {
+				continue
+			}
+			token, ok := sharedToken(a.Pattern, b.Pattern)
+			if !ok {
+				token, ok = sharedToken(b.Pattern, a.Pattern)
+			}
+			if ok {
+				overlaps = append(overlaps, Overlap{
+					Kind:   OverlapPrecedence,
+					First:  PatternRef{Section: "operator-regexp", Index: i, Pattern: a.Pattern},
+					Second: PatternRef{Section: "operator-regexp", Index: j, Pattern: b.Pattern},
+					Token:  token,
+				})
+			}
+		}
+	}
+	return overlaps
+}
+
+// samePrecedences reports whether two operators have the same precedences.
+func samePrecedences(a, b OperatorConfig) bool {
+	return a.PrefixPrec == b.PrefixPrec && a.InfixPrec == b.InfixPrec && a.PostfixPrec == b.PostfixPrec
+}
+
+// sharedToken returns a token sampled from the first pattern that the whole
+// of the second also matches, if there is one.
+func sharedToken(from, against string) (string, bool) {
+	re, err := regexp.Compile("^(?:" + against + ")$")
+	if err != nil {
+		return "", false
+	}
+	// A fixed seed keeps the report the same from run to run.
+	samples, err := gen.New(1).Samples(from, overlapSamples)
+	if err != nil {
+		return "", false
+	}
+	for _, sample := range samples {
+		if re.MatchString(sample) {
+			return sample, true
+		}
+	}
+	return "", false
+}
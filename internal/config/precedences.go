@@ -0,0 +1,76 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/sfkleach/re-classify/internal/gen"
+)
+
+// The positions of an operator, by which a precedence table can be sorted.
+const (
+	PositionPrefix  = "prefix"
+	PositionInfix   = "infix"
+	PositionPostfix = "postfix"
+)
+
+// Precedence is a row of a precedence table: an operator pattern with tokens
+// that it matches and its precedences, where 0 means that the operator
+// cannot appear in that position.
+type Precedence struct {
+	Index       int      `json:"index"` // Position in operator-regexp
+	Pattern     string   `json:"pattern"`
+	Samples     []string `json:"samples"`
+	PrefixPrec  uint16   `json:"prefix-prec"`
+	InfixPrec   uint16   `json:"infix-prec"`
+	PostfixPrec uint16   `json:"postfix-prec"`
+}
+
+// Prec returns the precedence of the operator in the given position.
+func (p Precedence) Prec(position string) uint16 {
+	switch position {
+	case PositionPrefix:
+		return p.PrefixPrec
+	case PositionPostfix:
+		return p.PostfixPrec
+	}
+	return p.InfixPrec
+}
+
+// Precedences returns the operators of the config sorted by their precedence
+// in the given position, lowest first, with up to samples tokens sampled from
+// each pattern. The operators that cannot appear in the position come last,
+// and operators with the same precedence keep the order of the config. A pattern that cannot be sampled, such as one that only the pcre
+// engine understands, has no samples.
+func (cc *ClassifierConfig) Precedences(position string, samples int) ([]Precedence, error) {
+	switch position {
+	case PositionPrefix, PositionInfix, PositionPostfix:
+	default:
+		return nil, fmt.Errorf("unknown position %q (expected %s, %s or %s)", position, PositionPrefix, PositionInfix, PositionPostfix)
+	}
+
+	// A fixed seed keeps the table the same from run to run.
+	g := gen.New(1)
+	table := make([]Precedence, 0, len(cc.OperatorRegexp))
+	for i, op := range cc.OperatorRegexp {
+		tokens, err := g.Samples(op.Pattern, samples)
+		if err != nil {
+			tokens = []string{}
+		}
+		slices.Sort(tokens)
+		table = append(table, Precedence{
+			Index:       i,
+			Pattern:     op.Pattern,
+			Samples:     tokens,
+			PrefixPrec:  op.PrefixPrec,
+			InfixPrec:   op.InfixPrec,
+			PostfixPrec: op.PostfixPrec,
+		})
+	}
+	slices.SortStableFunc(table, func(a, b Precedence) int {
+		// Subtracting one wraps 0 round to the largest precedence.
+		return cmp.Compare(a.Prec(position)-1, b.Prec(position)-1)
+	})
+	return table, nil
+}
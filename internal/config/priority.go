@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// SectionPatterns returns the patterns of one of the PatternSections. For
+// surround-regexp these are the start patterns, one per group.
+func (cc *ClassifierConfig) SectionPatterns(section string) []string {
+	switch section {
+	case "surround-regexp":
+		var starts []string
+		for _, s := range cc.SurroundRegexp {
+			starts = append(starts, s.StartPattern())
+		}
+		return starts
+	case "operator-regexp":
+		var patterns []string
+		for _, op := range cc.OperatorRegexp {
+			patterns = append(patterns, op.Pattern)
+		}
+		return patterns
+	case "form-prefix-regexp":
+		return cc.FormPrefixRegexp
+	case "simple-label-regexp":
+		return cc.SimpleLabelRegexp
+	case "compound-label-regexp":
+		return cc.CompoundLabelRegexp
+	case "variable-regexp":
+		return cc.VariableRegexp
+	case "comment-regexp":
+		return cc.CommentRegexp
+	case "string-regexp":
+		return cc.StringRegexp
+	case "number-regexp":
+		return cc.NumberRegexp
+	}
+	return nil
+}
+
+// checkPriorities validates the priority section: every pattern must belong
+// to the section it is listed under.
+func (cc *ClassifierConfig) checkPriorities() error {
+	for _, section := range slices.Sorted(maps.Keys(cc.Priority)) {
+		if !slices.Contains(PatternSections, section) {
+			return fmt.Errorf("priority: unknown section %q (expected one of %s)", section, strings.Join(PatternSections, ", "))
+		}
+		patterns := cc.SectionPatterns(section)
+		for _, pattern := range slices.Sorted(maps.Keys(cc.Priority[section])) {
+			if !slices.Contains(patterns, pattern) {
+				return fmt.Errorf("priority: %s has no pattern %q", section, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// priorityOrder returns the indexes of the patterns ordered by descending
+// priority and then, with the most-specific match strategy, by descending
+// specificity, keeping the declared order among equals. Within a table the
+// first matching pattern wins, so this is the order to add them in.
+func (cc *ClassifierConfig) priorityOrder(section string, patterns []string) []int {
+	order := make([]int, len(patterns))
+	specificity := make([]int, len(patterns))
+	mostSpecific := strings.ToLower(cc.MatchStrategy) == MatchMostSpecific
+	for i, pattern := range patterns {
+		order[i] = i
+		if mostSpecific {
+			specificity[i] = Specificity(pattern)
+		}
+	}
+	priorities := cc.Priority[section]
+	slices.SortStableFunc(order, func(a, b int) int {
+		if d := priorities[patterns[b]] - priorities[patterns[a]]; d != 0 {
+			return d
+		}
+		return specificity[b] - specificity[a]
+	})
+	return order
+}
+
+// byPriority returns the patterns reordered by priorityOrder.
+func (cc *ClassifierConfig) byPriority(section string, patterns []string) []string {
+	ordered := make([]string, 0, len(patterns))
+	for _, i := range cc.priorityOrder(section, patterns) {
+		ordered = append(ordered, patterns[i])
+	}
+	return ordered
+}
+
+// TableOrder returns the indexes of the patterns of a section in the order
+// they are added to its table, which is the order they are tried in.
+func (cc *ClassifierConfig) TableOrder(section string) []int {
+	return cc.priorityOrder(section, cc.SectionPatterns(section))
+}
+
+// sectionList returns the pattern list of a section that is a plain list of
+// patterns, or nil for surround-regexp and operator-regexp.
+func (cc *ClassifierConfig) sectionList(section string) *[]string {
+	switch section {
+	case "form-prefix-regexp":
+		return &cc.FormPrefixRegexp
+	case "simple-label-regexp":
+		return &cc.SimpleLabelRegexp
+	case "compound-label-regexp":
+		return &cc.CompoundLabelRegexp
+	case "variable-regexp":
+		return &cc.VariableRegexp
+	case "comment-regexp":
+		return &cc.CommentRegexp
+	case "string-regexp":
+		return &cc.StringRegexp
+	case "number-regexp":
+		return &cc.NumberRegexp
+	}
+	return nil
+}
+
+// Reorderable reports whether the patterns of a section can be declared in
+// any order. The position of a surround group or operator also determines
+// its serial number, so those sections cannot.
+func Reorderable(section string) bool {
+	return (&ClassifierConfig{}).sectionList(section) != nil
+}
+
+// Reordered returns a copy of the config in which the patterns of a
+// reorderable section are declared in the given order, a permutation of
+// their indexes. The other sections are shared with the original.
+func (cc *ClassifierConfig) Reordered(section string, order []int) *ClassifierConfig {
+	clone := *cc
+	list := clone.sectionList(section)
+	if list == nil {
+		return &clone
+	}
+	patterns := make([]string, 0, len(order))
+	for _, i := range order {
+		patterns = append(patterns, (*list)[i])
+	}
+	*list = patterns
+	return &clone
+}
+
+// SurroundOrder returns the indexes of the surround groups in the order
+// their start patterns should be tried.
+func (cc *ClassifierConfig) SurroundOrder() []int {
+	return cc.priorityOrder("surround-regexp", cc.SectionPatterns("surround-regexp"))
+}
+
+// Names of the strategies for choosing between several patterns of a section
+// that match the same token. Every pattern matches the whole token, so
+// patterns are ranked by how specific they are rather than by the length of
+// their matches.
+const (
+	MatchFirst        = "first"         // The first declared pattern wins
+	MatchMostSpecific = "most-specific" // The pattern with the most literal text wins
+)
+
+// checkMatchStrategy validates the match-strategy setting.
+func (cc *ClassifierConfig) checkMatchStrategy() error {
+	switch strings.ToLower(cc.MatchStrategy) {
+	case "", MatchFirst, MatchMostSpecific:
+		return nil
+	}
+	return fmt.Errorf("unknown match-strategy %q (expected %s or %s)", cc.MatchStrategy, MatchFirst, MatchMostSpecific)
+}
+
+// Specificity measures how specific a pattern is by the number of literal
+// characters that every match must contain, so "end_if" (6) is more specific
+// than "end_[a-z]+" (4), which is more specific than "[a-z_]+" (0). Invalid
+// patterns have specificity 0; they are reported when the table is built.
+func Specificity(pattern string) int {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	return literalLength(re.Simplify())
+}
+
+// literalLength counts the literal characters that every match of re must
+// contain.
+func literalLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalLength(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min * literalLength(re.Sub[0])
+	case syntax.OpConcat:
+		n := 0
+		for _, sub := range re.Sub {
+			n += literalLength(sub)
+		}
+		return n
+	case syntax.OpAlternate:
+		n := literalLength(re.Sub[0])
+		for _, sub := range re.Sub[1:] {
+			n = min(n, literalLength(sub))
+		}
+		return n
+	}
+	return 0
+}
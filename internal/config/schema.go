@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+)
+
+// Schema returns a JSON Schema describing the configuration file, derived
+// from the yaml tags of ClassifierConfig so that it cannot drift from what is
+// accepted.
+func Schema() map[string]any {
+	schema := schemaOf(reflect.TypeFor[ClassifierConfig]())
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "re-classify configuration"
+	addAliases(schema)
+	return schema
+}
+
+// addAliases adds the old keys of KeyAliases to the schema, marked as
+// deprecated, so that editors accept them but flag them.
+func addAliases(schema map[string]any) {
+	for _, alias := range KeyAliases {
+		properties, _ := schema["properties"].(map[string]any)
+		if alias.Parent != "" {
+			parent, _ := properties[alias.Parent].(map[string]any)
+			if items, ok := parent["items"].(map[string]any); ok {
+				parent = items
+			}
+			properties, _ = parent["properties"].(map[string]any)
+		}
+		replacement, ok := properties[alias.New].(map[string]any)
+		if !ok {
+			continue
+		}
+		property := maps.Clone(replacement)
+		property["deprecated"] = true
+		property["description"] = fmt.Sprintf("Deprecated in favour of %s, and to be removed in %s.", alias.New, alias.RemovedIn)
+		properties[alias.Old] = property
+	}
+}
+
+// schemaOf returns the schema of a Go type.
+func schemaOf(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := map[string]any{"type": "integer", "minimum": 0}
+		if t.Kind() == reflect.Uint16 {
+			schema["maximum"] = 1<<16 - 1
+		}
+		return schema
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			properties[name] = schemaOf(field.Type)
+		}
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return map[string]any{}
+}
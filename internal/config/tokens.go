@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The values of token-whitespace.
+const (
+	WhitespaceTrim     = "trim"     // Surrounding whitespace is removed from each line
+	WhitespacePreserve = "preserve" // Each line is the token, as it is
+)
+
+// The values of blank-tokens.
+const (
+	BlankSkip = "skip" // Blank lines are not tokens
+	BlankKeep = "keep" // Blank lines are empty tokens, classified like any other
+)
+
+// The values of case-folding.
+const (
+	CaseFoldingNone  = "none"  // Tokens are matched as they are
+	CaseFoldingLower = "lower" // Tokens are lowercased before matching
+)
+
+// The values of invalid-utf8.
+const (
+	InvalidUTF8PassThrough = "pass-through" // Lines are matched as they are
+	InvalidUTF8Replace     = "replace"      // Invalid bytes become U+FFFD, with a warning
+	InvalidUTF8Error       = "error"        // A line that is not valid UTF-8 is an error
+)
+
+// TokenPolicy says how the lines of the input become tokens. The zero value
+// trims each line, skips blank ones and passes invalid UTF-8 through.
+type TokenPolicy struct {
+	PreserveWhitespace bool
+	KeepBlank          bool
+	InvalidUTF8        string // One of the InvalidUTF8 values, "" meaning pass-through
+}
+
+// TokenPolicy returns the policy selected by the token-whitespace,
+// blank-tokens and invalid-utf8 settings.
+func (cc *ClassifierConfig) TokenPolicy() (TokenPolicy, error) {
+	var policy TokenPolicy
+	switch strings.ToLower(cc.TokenWhitespace) {
+	case "", WhitespaceTrim:
+	case WhitespacePreserve:
+		policy.PreserveWhitespace = true
+	default:
+		return policy, fmt.Errorf("unknown token-whitespace %q (expected %s or %s)", cc.TokenWhitespace, WhitespaceTrim, WhitespacePreserve)
+	}
+	switch strings.ToLower(cc.BlankTokens) {
+	case "", BlankSkip:
+	case BlankKeep:
+		policy.KeepBlank = true
+	default:
+		return policy, fmt.Errorf("unknown blank-tokens %q (expected %s or %s)", cc.BlankTokens, BlankSkip, BlankKeep)
+	}
+	switch invalid := strings.ToLower(cc.InvalidUTF8); invalid {
+	case "", InvalidUTF8PassThrough:
+	case InvalidUTF8Replace, InvalidUTF8Error:
+		policy.InvalidUTF8 = invalid
+	default:
+		return policy, fmt.Errorf("unknown invalid-utf8 %q (expected %s, %s or %s)", cc.InvalidUTF8, InvalidUTF8PassThrough, InvalidUTF8Replace, InvalidUTF8Error)
+	}
+	return policy, nil
+}
+
+// caseFolding returns the function that the case-folding setting applies to
+// tokens, or nil if tokens are matched as they are.
+func (cc *ClassifierConfig) caseFolding() (func(string) string, error) {
+	switch strings.ToLower(cc.CaseFolding) {
+	case "", CaseFoldingNone:
+		return nil, nil
+	case CaseFoldingLower:
+		return strings.ToLower, nil
+	}
+	return nil, fmt.Errorf("unknown case-folding %q (expected %s or %s)", cc.CaseFolding, CaseFoldingNone, CaseFoldingLower)
+}
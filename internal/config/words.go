@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// CompiledWords holds the words of a words file, which a section matches
+// literally as well as by its patterns.
+type CompiledWords struct {
+	File  string // Where the words were read from
+	Words map[string]bool
+}
+
+// WordsFiles returns the words file of each section that has one.
+func (cc *ClassifierConfig) WordsFiles() map[string]string {
+	files := make(map[string]string)
+	for section, file := range map[string]string{
+		"form-prefix-regexp":    cc.FormPrefixWordsFile,
+		"simple-label-regexp":   cc.SimpleLabelWordsFile,
+		"compound-label-regexp": cc.CompoundLabelWordsFile,
+		"variable-regexp":       cc.VariableWordsFile,
+	} {
+		if file != "" {
+			files[section] = file
+		}
+	}
+	return files
+}
+
+// ExternalSources lists the sources, besides the config itself, that
+// compiling the config reads: its words files and hook modules. Their
+// content is not part of the Fingerprint.
+func (cc *ClassifierConfig) ExternalSources() []string {
+	files := cc.WordsFiles()
+	sources := make([]string, 0, len(files)+len(cc.Hooks))
+	for _, section := range slices.Sorted(maps.Keys(files)) {
+		sources = append(sources, files[section])
+	}
+	for _, hook := range cc.Hooks {
+		sources = append(sources, hook.Wasm)
+	}
+	return sources
+}
+
+// compileWords reads the words files of the sections. Each word is
+// normalized as the tokens are, so that it matches them.
+func (cc *ClassifierConfig) compileWords(normalize func(string) string) (map[string]*CompiledWords, error) {
+	files := cc.WordsFiles()
+	if len(files) == 0 {
+		return nil, nil
+	}
+	compiled := make(map[string]*CompiledWords, len(files))
+	for _, section := range slices.Sorted(maps.Keys(files)) {
+		file := files[section]
+		key := strings.TrimSuffix(section, "-regexp") + "-words-file"
+		data, err := ReadSource(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		words, err := parseWords(data, normalize)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", key, file, err)
+		}
+		compiled[section] = &CompiledWords{File: file, Words: words}
+	}
+	return compiled, nil
+}
+
+// parseWords parses a words file: a word per line, with surrounding
+// whitespace trimmed. Blank lines and lines starting with # are skipped.
+func parseWords(data []byte, normalize func(string) string) (map[string]bool, error) {
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if normalize != nil {
+			word = normalize(word)
+		}
+		words[word] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
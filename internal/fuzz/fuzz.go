@@ -0,0 +1,289 @@
+// Package fuzz throws randomized token streams at a classifier engine to
+// check that a config and the engine cope with hostile input: no panics, no
+// runaway matching and the same answer every time.
+package fuzz
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/gen"
+)
+
+// Kinds of failure.
+const (
+	KindPanic    = "panic"
+	KindTimeout  = "timeout"
+	KindUnstable = "unstable"
+)
+
+// samplesPerPattern is the number of samples generated from each pattern of
+// the config, which seed the token streams.
+const samplesPerPattern = 10
+
+// edgeCases are tokens that are awkward for normalization, case folding and
+// UTF-8 handling.
+var edgeCases = []string{
+	"",
+	" ",
+	"\t",
+	"\r",
+	"\x00",
+	"\ufeff",                     // byte order mark
+	"\u200b",                     // zero width space
+	"\u200d",                     // zero width joiner
+	"\u202e",                     // right-to-left override
+	"e\u0301",                    // decomposed e acute
+	"\u00e9",                     // precomposed e acute
+	"\ufb01",                     // fi ligature
+	"\u212b",                     // angstrom sign
+	"\u0130",                     // dotted capital I
+	"\u00df",                     // sharp s
+	"\U0001f468\u200d\U0001f469", // emoji joined by zero width joiner
+	strings.Repeat("\u0301", 64),
+	"\xff\xfe",     // invalid UTF-8
+	"\xc0\x80",     // overlong NUL
+	"\xed\xa0\x80", // encoded surrogate
+	"\xe2\x82",     // truncated sequence
+}
+
+// Options controls a fuzzing run.
+type Options struct {
+	Iterations int           // The number of token streams to try
+	MaxTokens  int           // The maximum number of tokens in a stream
+	HugeBytes  int           // The size of the huge token of an occasional stream
+	Timeout    time.Duration // The time allowed for each stream
+	Seed       uint64        // Seed for reproducible streams
+}
+
+// Failure describes a problem found while fuzzing.
+type Failure struct {
+	Iteration int
+	Kind      string
+	Token     string
+	Detail    string
+}
+
+func (f Failure) String() string {
+	token := f.Token
+	if len(token) > 40 {
+		token = fmt.Sprintf("%s... (%d bytes)", token[:40], len(f.Token))
+	}
+	return fmt.Sprintf("iteration %d: %s: token %q: %s", f.Iteration, f.Kind, token, f.Detail)
+}
+
+// Stats summarizes a fuzzing run.
+type Stats struct {
+	Iterations int
+	Tokens     int
+	Failures   int
+}
+
+// Fuzzer generates token streams and checks how an engine classifies them.
+type Fuzzer struct {
+	engine  *classifier.ClassifierEngine
+	cfg     *config.ClassifierConfig
+	opts    Options
+	rng     *rand.Rand
+	samples []string
+}
+
+// New returns a fuzzer for the engine, seeding its streams with samples of
+// every pattern of the config.
+func New(engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, opts Options) (*Fuzzer, error) {
+	g := gen.New(opts.Seed)
+	var samples []string
+	for _, section := range config.PatternSections {
+		for _, pattern := range cfg.SectionPatterns(section) {
+			// The generator only knows RE2 syntax, so patterns that use the
+			// features of the pcre engine are left to the other samples.
+			s, err := g.Samples(pattern, samplesPerPattern)
+			if err != nil {
+				slog.Warn("cannot generate samples", "section", section, "pattern", pattern, "error", err)
+				continue
+			}
+			samples = append(samples, s...)
+		}
+	}
+	for _, surround := range cfg.SurroundRegexp {
+		for _, pattern := range slices.Concat(surround.Endings, surround.Intermediates) {
+			// Endings may refer to the groups of the start, which the
+			// generator knows nothing about, so they are optional.
+			if s, err := g.Samples(pattern, samplesPerPattern); err == nil {
+				samples = append(samples, s...)
+			}
+		}
+	}
+	if len(samples) == 0 {
+		samples = []string{"x"}
+	}
+	return &Fuzzer{
+		engine:  engine,
+		cfg:     cfg,
+		opts:    opts,
+		rng:     rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15)),
+		samples: samples,
+	}, nil
+}
+
+// Run tries Options.Iterations streams, calling report for each failure. A
+// timeout ends the run, because the stream that timed out is still running.
+func (f *Fuzzer) Run(report func(Failure)) Stats {
+	var stats Stats
+	for i := 1; i <= f.opts.Iterations; i++ {
+		tokens := f.stream()
+		stats.Iterations++
+		stats.Tokens += len(tokens)
+
+		done := make(chan []Failure, 1)
+		go func() { done <- f.check(i, tokens) }()
+		select {
+		case failures := <-done:
+			for _, failure := range failures {
+				report(failure)
+			}
+			stats.Failures += len(failures)
+		case <-time.After(f.opts.Timeout):
+			report(Failure{
+				Iteration: i,
+				Kind:      KindTimeout,
+				Token:     longest(tokens),
+				Detail:    fmt.Sprintf("stream of %d tokens took more than %s", len(tokens), f.opts.Timeout),
+			})
+			stats.Failures++
+			return stats
+		}
+	}
+	return stats
+}
+
+// check classifies one stream, looking for panics and for tokens whose
+// classification changes when repeated or when the mappings are rebuilt.
+func (f *Fuzzer) check(iteration int, tokens []string) (failures []Failure) {
+	fail := func(kind, token, detail string) {
+		failures = append(failures, Failure{Iteration: iteration, Kind: kind, Token: token, Detail: detail})
+	}
+
+	first, ok := f.classifyAll(tokens, fail)
+	if !ok {
+		return failures
+	}
+	second, ok := f.classifyAll(tokens, fail)
+	if !ok {
+		return failures
+	}
+	for i, token := range tokens {
+		if first[i] != second[i] {
+			fail(KindUnstable, token, fmt.Sprintf("classified as %s, then as %s", first[i], second[i]))
+		}
+	}
+	return failures
+}
+
+// classifyAll builds fresh form mappings for the stream and classifies each
+// token twice over, returning the classifications. Panics are reported
+// through fail, in which case ok is false.
+func (f *Fuzzer) classifyAll(tokens []string, fail func(kind, token, detail string)) (codes []string, ok bool) {
+	var current string
+	defer func() {
+		if r := recover(); r != nil {
+			fail(KindPanic, current, fmt.Sprintf("%v\n%s", r, debug.Stack()))
+			ok = false
+		}
+	}()
+
+	engine := f.engine.Clone()
+	if err := engine.BuildFormStartEndMappings(tokens, f.cfg); err != nil {
+		// Errors are an honest answer to bad input, not a failure.
+		return nil, false
+	}
+	codes = make([]string, len(tokens))
+	for i, token := range tokens {
+		current = token
+		codes[i] = engine.Classify(token).String()
+		if again := engine.Classify(token).String(); again != codes[i] {
+			fail(KindUnstable, token, fmt.Sprintf("classified as %s, then as %s", codes[i], again))
+		}
+	}
+	current = ""
+	if err := engine.WriteClassifications(io.Discard, tokens, &classifier.ProcessOptions{}); err != nil {
+		return nil, false
+	}
+	return codes, true
+}
+
+// hugeStreams is the number of streams in which one has a huge token. They
+// are slow to classify, so they are kept occasional.
+const hugeStreams = 10
+
+// stream returns a random token stream.
+func (f *Fuzzer) stream() []string {
+	n := 1 + f.rng.IntN(f.opts.MaxTokens)
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = f.token()
+	}
+	if f.rng.IntN(hugeStreams) == 0 {
+		tokens[f.rng.IntN(n)] = f.huge()
+	}
+	return tokens
+}
+
+// token returns a random token, mostly derived from the samples so that the
+// streams reach the interesting parts of the config.
+func (f *Fuzzer) token() string {
+	sample := f.samples[f.rng.IntN(len(f.samples))]
+	switch r := f.rng.IntN(100); {
+	case r < 50:
+		return sample
+	case r < 60:
+		return sample + f.samples[f.rng.IntN(len(f.samples))]
+	case r < 75:
+		edge := edgeCases[f.rng.IntN(len(edgeCases))]
+		at := f.rng.IntN(len(sample) + 1)
+		return sample[:at] + edge + sample[at:]
+	case r < 90:
+		return edgeCases[f.rng.IntN(len(edgeCases))]
+	default:
+		return f.randomBytes(1 + f.rng.IntN(16))
+	}
+}
+
+// huge returns a token of more than Options.HugeBytes, a sample repeated.
+func (f *Fuzzer) huge() string {
+	sample := f.samples[f.rng.IntN(len(f.samples))]
+	if sample == "" {
+		sample = "x"
+	}
+	return strings.Repeat(sample, f.opts.HugeBytes/len(sample)+1)
+}
+
+// randomBytes returns a string of n random bytes, which is rarely valid
+// UTF-8.
+func (f *Fuzzer) randomBytes(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(f.rng.UintN(256))
+	}
+	return string(b)
+}
+
+// longest returns the longest of the tokens, the likeliest culprit for a
+// timeout.
+func longest(tokens []string) string {
+	var result string
+	for _, token := range tokens {
+		if len(token) > len(result) {
+			result = token
+		}
+	}
+	return result
+}
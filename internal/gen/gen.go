@@ -0,0 +1,139 @@
+// Package gen synthesizes example strings that match regular expressions,
+// for exposing patterns that match more than their author intended.
+package gen
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// maxRepeat limits the extra repetitions generated for *, + and {n,}.
+const maxRepeat = 3
+
+// Generator produces random strings that match regular expressions.
+type Generator struct {
+	rng *rand.Rand
+}
+
+// New returns a generator seeded for reproducible output.
+func New(seed uint64) *Generator {
+	return &Generator{rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Samples returns up to n distinct strings that match the whole of the
+// pattern. Fewer are returned when the pattern matches fewer strings.
+func (g *Generator) Samples(pattern string, n int) ([]string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	re = re.Simplify()
+
+	var samples []string
+	seen := make(map[string]bool)
+	for attempt := 0; len(samples) < n && attempt < 10*n; attempt++ {
+		var sb strings.Builder
+		if !g.generate(&sb, re) {
+			return nil, fmt.Errorf("pattern %q cannot match anything", pattern)
+		}
+		if s := sb.String(); !seen[s] {
+			seen[s] = true
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
+
+// generate appends a random match of re to sb. It returns false if re can
+// never match.
+func (g *Generator) generate(sb *strings.Builder, re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && g.rng.IntN(2) == 0 {
+				r = swapCase(r)
+			}
+			sb.WriteRune(r)
+		}
+	case syntax.OpCharClass:
+		sb.WriteRune(g.pick(re.Rune))
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		// Printable ASCII keeps the samples readable.
+		sb.WriteRune(rune('!' + g.rng.IntN('~'-'!'+1)))
+	case syntax.OpCapture:
+		return g.generate(sb, re.Sub[0])
+	case syntax.OpStar:
+		return g.repeat(sb, re.Sub[0], 0, maxRepeat)
+	case syntax.OpPlus:
+		return g.repeat(sb, re.Sub[0], 1, 1+maxRepeat)
+	case syntax.OpQuest:
+		return g.repeat(sb, re.Sub[0], 0, 1)
+	case syntax.OpRepeat:
+		hi := re.Max
+		if hi < 0 {
+			hi = re.Min + maxRepeat
+		}
+		return g.repeat(sb, re.Sub[0], re.Min, hi)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !g.generate(sb, sub) {
+				return false
+			}
+		}
+	case syntax.OpAlternate:
+		// Try the alternatives in a random order until one can match.
+		for _, i := range g.rng.Perm(len(re.Sub)) {
+			var alt strings.Builder
+			if g.generate(&alt, re.Sub[i]) {
+				sb.WriteString(alt.String())
+				return true
+			}
+		}
+		return false
+	}
+	// The remaining ops, such as anchors and word boundaries, match the
+	// empty string.
+	return true
+}
+
+// repeat appends between lo and hi matches of re.
+func (g *Generator) repeat(sb *strings.Builder, re *syntax.Regexp, lo, hi int) bool {
+	var reps strings.Builder
+	n := lo + g.rng.IntN(hi-lo+1)
+	for range n {
+		if !g.generate(&reps, re) {
+			// Zero repetitions still match.
+			return lo == 0
+		}
+	}
+	sb.WriteString(reps.String())
+	return true
+}
+
+// pick chooses a random rune from a character class, given as pairs of
+// inclusive bounds. Printable ASCII is preferred, so that a negated class
+// does not produce control characters or unassigned code points.
+func (g *Generator) pick(ranges []rune) rune {
+	var ascii []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := max(ranges[i], '!'), min(ranges[i+1], '~')
+		if lo <= hi {
+			ascii = append(ascii, lo, hi)
+		}
+	}
+	if len(ascii) > 0 {
+		ranges = ascii
+	}
+	i := 2 * g.rng.IntN(len(ranges)/2)
+	return ranges[i] + rune(g.rng.IntN(int(ranges[i+1]-ranges[i])+1))
+}
+
+// swapCase changes the case of a letter.
+func swapCase(r rune) rune {
+	return unicode.SimpleFold(r)
+}
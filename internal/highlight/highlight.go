@@ -0,0 +1,84 @@
+package highlight
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+)
+
+// Format selects how highlighted tokens are rendered.
+type Format string
+
+const (
+	ANSI Format = "ansi" // ANSI escape sequences for terminals
+	HTML Format = "html" // HTML spans for documentation
+)
+
+// ParseFormat checks the name of a highlighting format.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case ANSI, HTML:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unknown highlight format %q (expected ansi or html)", name)
+}
+
+// style describes how a single class code is rendered.
+type style struct {
+	ansi      string // SGR parameters
+	htmlClass string // CSS class name
+}
+
+// styles maps the 1-letter classification codes onto their styles.
+var styles = map[string]style{
+	"S": {ansi: "1;35", htmlClass: "rc-form-start"},
+	"E": {ansi: "1;35", htmlClass: "rc-form-end"},
+	"I": {ansi: "1;35", htmlClass: "rc-intermediate"},
+	"C": {ansi: "1;34", htmlClass: "rc-compound-label"},
+	"L": {ansi: "34", htmlClass: "rc-simple-label"},
+	"P": {ansi: "1;36", htmlClass: "rc-form-prefix"},
+	"O": {ansi: "33", htmlClass: "rc-operator"},
+	"[": {ansi: "32", htmlClass: "rc-open-delimiter"},
+	"]": {ansi: "32", htmlClass: "rc-close-delimiter"},
+	"V": {ansi: "", htmlClass: "rc-variable"},
+	"U": {ansi: "2", htmlClass: "rc-unclassified"},
+	"#": {ansi: "3;32", htmlClass: "rc-comment"},
+	"Q": {ansi: "31", htmlClass: "rc-string"},
+	"N": {ansi: "36", htmlClass: "rc-number"},
+}
+
+// Write renders the tokens, separated by spaces, colorized according to the
+// corresponding classification codes. The codes must be congruent with the
+// tokens.
+func Write(w io.Writer, format Format, tokens []string, codes []string) error {
+	if len(tokens) != len(codes) {
+		return fmt.Errorf("highlight: %d tokens but %d codes", len(tokens), len(codes))
+	}
+
+	bw := bufio.NewWriter(w)
+	if format == HTML {
+		bw.WriteString(`<pre class="monogram">`)
+	}
+	for i, token := range tokens {
+		if i > 0 {
+			bw.WriteByte(' ')
+		}
+		s := styles[codes[i]]
+		switch format {
+		case ANSI:
+			if s.ansi == "" {
+				bw.WriteString(token)
+			} else {
+				fmt.Fprintf(bw, "\x1b[%sm%s\x1b[0m", s.ansi, token)
+			}
+		case HTML:
+			fmt.Fprintf(bw, `<span class="%s">%s</span>`, s.htmlClass, html.EscapeString(token))
+		}
+	}
+	if format == HTML {
+		bw.WriteString("</pre>")
+	}
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
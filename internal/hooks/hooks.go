@@ -0,0 +1,169 @@
+// Package hooks runs WebAssembly modules that classify tokens, for the rules
+// that regular expressions cannot express, such as checksums, length limits
+// and dictionaries. A hook module exports:
+//
+//	memory                              its linear memory
+//	alloc(len i32) i32                  space for a token of len bytes
+//	classify(ptr i32, len i32, code i32) i32
+//
+// Before each call of classify the token is written, as UTF-8, to the space
+// that alloc returns, which may be the same each time. The code is the
+// Unicode code point of the classification that the regex tables gave the
+// token, such as 'V', or 0 if the hook runs before them. classify returns 0
+// to leave the classification alone, or the code point of a code to
+// classify the token as instead: one of the codes without additional data,
+// see Codes, where 'U' vetoes the tables' classification.
+//
+// Modules may import WASI, as those built by Go and TinyGo do, but have no
+// access to files, arguments or the environment. A reactor module's
+// _initialize function is called when it is instantiated. Each call is given
+// a time limit, after which the instance is closed.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Codes lists the classification codes that a hook may return. The others
+// carry data, such as the endings of a form-start or the precedences of an
+// operator, that a hook cannot supply.
+var Codes = []string{"#", "Q", "N", "C", "L", "P", "V", "U"}
+
+// CallTimeout limits the time taken by a single call of a hook, including
+// the _initialize function. A call that takes longer is abandoned and the
+// instance closed, so that a hook that loops cannot hang the classifier.
+const CallTimeout = time.Second
+
+// Module is a compiled hook module. It is safe for concurrent use, but each
+// goroutine needs its own Instance.
+type Module struct {
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// Compile compiles a hook module, checking that it has the exports that a
+// hook needs. The name identifies it in errors. The module lives as long as
+// the process.
+func Compile(name string, wasm []byte) (*Module, error) {
+	ctx := context.Background()
+	// Closing on a done context is what lets CallTimeout stop a call.
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("hook %s: %w", name, err)
+	}
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("hook %s: %w", name, err)
+	}
+	exports := compiled.ExportedFunctions()
+	for _, export := range []struct {
+		name    string
+		params  int
+		results int
+	}{{"alloc", 1, 1}, {"classify", 3, 1}} {
+		f, ok := exports[export.name]
+		if !ok {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("hook %s: missing export %s", name, export.name)
+		}
+		if len(f.ParamTypes()) != export.params || len(f.ResultTypes()) != export.results {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("hook %s: export %s has the wrong signature", name, export.name)
+		}
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("hook %s: missing export memory", name)
+	}
+	return &Module{name: name, runtime: runtime, compiled: compiled}, nil
+}
+
+// Name returns the name that the module was compiled with.
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Instance is an instance of a hook module, with its own memory. It must not
+// be used by more than one goroutine at a time.
+type Instance struct {
+	name     string
+	module   api.Module
+	alloc    api.Function
+	classify api.Function
+}
+
+// Instantiate returns a new instance of the module.
+func (m *Module) Instantiate() (*Instance, error) {
+	// Anonymous instances let the module be instantiated more than once.
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	ctx, cancel := context.WithTimeout(context.Background(), CallTimeout)
+	defer cancel()
+	module, err := m.runtime.InstantiateModule(ctx, m.compiled, config)
+	if err != nil {
+		return nil, fmt.Errorf("hook %s: %w", m.name, timedOut(err))
+	}
+	return &Instance{
+		name:     m.name,
+		module:   module,
+		alloc:    module.ExportedFunction("alloc"),
+		classify: module.ExportedFunction("classify"),
+	}, nil
+}
+
+// Classify passes a token to the hook together with its code so far, empty
+// if it has not been classified, and returns the code that the hook gives
+// it, or "" if the hook leaves it alone.
+func (in *Instance) Classify(token, code string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CallTimeout)
+	defer cancel()
+	results, err := in.alloc.Call(ctx, uint64(len(token)))
+	if err != nil {
+		return "", fmt.Errorf("hook %s: alloc: %w", in.name, timedOut(err))
+	}
+	ptr := uint32(results[0])
+	if !in.module.Memory().WriteString(ptr, token) {
+		return "", fmt.Errorf("hook %s: alloc returned %d, outside its memory", in.name, ptr)
+	}
+	var current rune
+	if code != "" {
+		current = []rune(code)[0]
+	}
+	results, err = in.classify.Call(ctx, uint64(ptr), uint64(len(token)), uint64(current))
+	if err != nil {
+		return "", fmt.Errorf("hook %s: classify: %w", in.name, timedOut(err))
+	}
+	result := rune(int32(results[0]))
+	if result == 0 {
+		return "", nil
+	}
+	if !slices.Contains(Codes, string(result)) {
+		return "", fmt.Errorf("hook %s: classify returned %q, which is not one of %s", in.name, result, strings.Join(Codes, " "))
+	}
+	return string(result), nil
+}
+
+// Closed reports whether the instance has been closed, e.g. because a call
+// took longer than CallTimeout, and so can no longer be used.
+func (in *Instance) Closed() bool {
+	return in.module.IsClosed()
+}
+
+// timedOut explains an error that comes from running out of time.
+func timedOut(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("took longer than %s: %w", CallTimeout, err)
+	}
+	return err
+}
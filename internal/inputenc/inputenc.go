@@ -0,0 +1,195 @@
+// Package inputenc converts token input in various text encodings to UTF-8.
+package inputenc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Names of the supported encodings.
+const (
+	UTF8    = "utf-8"
+	UTF16   = "utf-16" // Byte order taken from the BOM, little-endian if absent
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+	Latin1  = "latin-1"
+)
+
+// Names lists the supported encodings, for help messages.
+var Names = []string{UTF8, UTF16, UTF16LE, UTF16BE, Latin1}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Check reports whether an encoding name is acceptable to NewReader,
+// without reading anything.
+func Check(name string) error {
+	_, err := normalize(name)
+	return err
+}
+
+// normalize canonicalizes an encoding name, accepting common aliases.
+func normalize(name string) (string, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "_", "-")) {
+	case "", "utf-8", "utf8":
+		return UTF8, nil
+	case "utf-16", "utf16":
+		return UTF16, nil
+	case "utf-16le", "utf16le":
+		return UTF16LE, nil
+	case "utf-16be", "utf16be":
+		return UTF16BE, nil
+	case "latin-1", "latin1", "iso-8859-1":
+		return Latin1, nil
+	}
+	return "", fmt.Errorf("unknown input encoding %q (expected one of %s)", name, strings.Join(Names, ", "))
+}
+
+// NewReader returns a reader that decodes r from the named encoding to UTF-8.
+// A byte order mark at the start of the input is detected and stripped. For
+// the Unicode encodings the BOM takes precedence over the name, so a UTF-16
+// file produced by Windows tooling is decoded correctly even with the
+// default utf-8. Latin-1 has no BOM, so no detection is done for it.
+func NewReader(r io.Reader, name string) (io.Reader, error) {
+	encoding, err := normalize(name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	if encoding == Latin1 {
+		return &latin1Reader{r: br}, nil
+	}
+
+	prefix, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(prefix, bomUTF8):
+		br.Discard(len(bomUTF8))
+		return br, nil
+	case bytes.HasPrefix(prefix, bomUTF16LE):
+		br.Discard(len(bomUTF16LE))
+		return &utf16Reader{r: br, bigEndian: false}, nil
+	case bytes.HasPrefix(prefix, bomUTF16BE):
+		br.Discard(len(bomUTF16BE))
+		return &utf16Reader{r: br, bigEndian: true}, nil
+	}
+
+	switch encoding {
+	case UTF16, UTF16LE:
+		return &utf16Reader{r: br, bigEndian: false}, nil
+	case UTF16BE:
+		return &utf16Reader{r: br, bigEndian: true}, nil
+	}
+	return br, nil
+}
+
+// latin1Reader decodes ISO-8859-1, in which every byte is the code point of
+// the same value.
+type latin1Reader struct {
+	r       *bufio.Reader
+	pending []byte // Encoded output that did not fit in the caller's buffer
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			m := copy(p[n:], l.pending)
+			l.pending = l.pending[m:]
+			n += m
+			continue
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		l.pending = utf8.AppendRune(l.pending[:0], rune(b))
+	}
+	return n, nil
+}
+
+// utf16Reader decodes UTF-16 in either byte order. Unpaired surrogates and a
+// trailing odd byte are decoded as U+FFFD.
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+	pending   []byte
+}
+
+// readUnit reads a single 16-bit code unit.
+func (u *utf16Reader) readUnit() (uint16, error) {
+	b0, err := u.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	b1, err := u.r.ReadByte()
+	if err == io.EOF {
+		return utf8.RuneError, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if u.bigEndian {
+		return uint16(b0)<<8 | uint16(b1), nil
+	}
+	return uint16(b1)<<8 | uint16(b0), nil
+}
+
+// readRune reads a single code point, combining surrogate pairs.
+func (u *utf16Reader) readRune() (rune, error) {
+	unit, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	r := rune(unit)
+	if !utf16.IsSurrogate(r) {
+		return r, nil
+	}
+	// Look ahead for the low surrogate without consuming anything else.
+	next, err := u.r.Peek(2)
+	if err != nil || len(next) < 2 {
+		return utf8.RuneError, nil
+	}
+	var low rune
+	if u.bigEndian {
+		low = rune(next[0])<<8 | rune(next[1])
+	} else {
+		low = rune(next[1])<<8 | rune(next[0])
+	}
+	decoded := utf16.DecodeRune(r, low)
+	if decoded == utf8.RuneError {
+		return utf8.RuneError, nil
+	}
+	u.r.Discard(2)
+	return decoded, nil
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(u.pending) > 0 {
+			m := copy(p[n:], u.pending)
+			u.pending = u.pending[m:]
+			n += m
+			continue
+		}
+		r, err := u.readRune()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		u.pending = utf8.AppendRune(u.pending[:0], r)
+	}
+	return n, nil
+}
@@ -0,0 +1,70 @@
+// Package jsonl reads tokens that have already been split out by an upstream
+// tokenizer, given one JSON object per line together with their positions.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Token is a token and the position that the upstream tokenizer gave it,
+// which is passed through to the structured outputs untouched.
+type Token struct {
+	Token string `json:"token"`
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	Col   int    `json:"col,omitempty"`
+}
+
+// Read reads the tokens from r, one JSON object per line, skipping blank
+// lines. Other fields of the objects are ignored. Surrounding whitespace is
+// trimmed from each token, as it is from the lines of plain input, and
+// tokens that are then empty or contain a line break are rejected.
+func Read(r io.Reader) ([]Token, error) {
+	reader := bufio.NewReader(r)
+	var tokens []Token
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error reading tokens: %w", err)
+		}
+		if text = bytes.TrimSpace(text); len(text) == 0 {
+			if err != nil {
+				return tokens, nil
+			}
+			continue
+		}
+		var token Token
+		if err := json.Unmarshal(text, &token); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		token.Token = strings.TrimSpace(token.Token)
+		switch {
+		case token.Token == "":
+			return nil, fmt.Errorf("line %d: missing token", line)
+		case strings.ContainsAny(token.Token, "\r\n"):
+			return nil, fmt.Errorf("line %d: token %q contains a line break", line, token.Token)
+		case token.Line < 0 || token.Col < 0:
+			return nil, fmt.Errorf("line %d: negative position", line)
+		}
+		tokens = append(tokens, token)
+		if err != nil {
+			return tokens, nil
+		}
+	}
+}
+
+// Lines returns the tokens one per line, as plain input.
+func Lines(tokens []Token) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token.Token)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
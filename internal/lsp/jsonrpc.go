@@ -0,0 +1,92 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// maxMessageBytes is the largest Content-Length that is accepted.
+const maxMessageBytes = 64 << 20
+
+// message is a JSON-RPC 2.0 request or notification.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// readMessage reads a single message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	headers, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	header := strings.TrimSpace(headers.Get("Content-Length"))
+	length, err := strconv.Atoi(header)
+	if err != nil || length < 0 || length > maxMessageBytes {
+		return nil, &framingError{responseError{Code: codeInvalidRequest, Message: fmt.Sprintf("invalid Content-Length %q, expected 0 to %d", header, maxMessageBytes)}}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return &msg, nil
+}
+
+// writeResponse writes a single response framed by a Content-Length header.
+// Exactly one of result and error is included, as JSON-RPC requires.
+func writeResponse(w io.Writer, id *json.RawMessage, result any, rpcErr *responseError) error {
+	msg := map[string]any{"jsonrpc": "2.0", "id": id}
+	if rpcErr != nil {
+		msg["error"] = rpcErr
+	} else {
+		msg["result"] = result
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// framingError is a message whose length cannot be read, which is answered
+// with an error, but after which the stream cannot be followed.
+type framingError struct {
+	responseError
+}
+
+func (e *framingError) Unwrap() error {
+	return &e.responseError
+}
@@ -0,0 +1,268 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/tokenizer"
+)
+
+// tokenTypes is the semantic tokens legend. The index of each entry is the
+// token type sent to the client.
+var tokenTypes = []string{"keyword", "modifier", "operator", "variable", "string", "number", "comment"}
+
+// codeTokenTypes maps classification codes onto indexes in tokenTypes. Codes
+// that are absent, such as delimiters and unclassified tokens, are not
+// highlighted.
+var codeTokenTypes = map[string]int{
+	"S": 0, "E": 0, "I": 0, "C": 0, "L": 0,
+	"P": 1,
+	"O": 2,
+	"V": 3,
+	"Q": 4,
+	"N": 5,
+	"#": 6,
+}
+
+// kindTokenTypes maps the kinds of literal token onto indexes in tokenTypes.
+var kindTokenTypes = map[tokenizer.Kind]int{
+	tokenizer.String:  4,
+	tokenizer.Number:  5,
+	tokenizer.Comment: 6,
+}
+
+// Server is a minimal Language Server that provides semantic tokens for
+// monogram files, using the classifier to decide the token types.
+type Server struct {
+	cfg       *config.ClassifierConfig
+	engine    *classifier.ClassifierEngine
+	documents map[string]string
+	shutdown  bool
+}
+
+// NewServer creates a language server that classifies using the given
+// configuration.
+func NewServer(cfg *config.ClassifierConfig, compiled *config.CompiledClassifierConfig) *Server {
+	return &Server{
+		cfg:       cfg,
+		engine:    classifier.NewClassifierEngine(compiled),
+		documents: make(map[string]string),
+	}
+}
+
+// Serve reads requests from r and writes responses to w until the client
+// sends the exit notification, the input ends or ctx is cancelled. A
+// cancellation takes effect between messages, so the message being handled
+// is always answered. It returns an error if the client exits without first
+// requesting shutdown.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	// Reading blocks, so it happens in its own goroutine, which is abandoned
+	// if ctx is cancelled first.
+	type read struct {
+		msg *message
+		err error
+	}
+	reads := make(chan read)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			msg, err := readMessage(br)
+			select {
+			case reads <- read{msg, err}:
+			case <-done:
+				return
+			}
+			var rpcErr *responseError
+			var framingErr *framingError
+			if err != nil && (!errors.As(err, &rpcErr) || errors.As(err, &framingErr)) {
+				return
+			}
+		}
+	}()
+
+	for {
+		var next read
+		select {
+		case next = <-reads:
+		case <-ctx.Done():
+			return nil
+		}
+		msg, err := next.msg, next.err
+		if err != nil {
+			var rpcErr *responseError
+			if errors.As(err, &rpcErr) {
+				if err := writeResponse(w, nullID(), nil, rpcErr); err != nil {
+					return err
+				}
+				var framingErr *framingError
+				if errors.As(err, &framingErr) {
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit received before shutdown")
+			}
+			return nil
+		}
+		result, rpcErr := s.handle(msg)
+		if msg.ID == nil {
+			continue // Notifications do not get a response
+		}
+		if err := writeResponse(w, msg.ID, result, rpcErr); err != nil {
+			return err
+		}
+	}
+}
+
+// nullID is the id used when responding to a request that could not be parsed.
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type textDocumentParams struct {
+	TextDocument   textDocumentItem `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// handle dispatches a single request or notification.
+func (s *Server) handle(msg *message) (any, *responseError) {
+	var params textDocumentParams
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": 1, // Full document sync
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{
+						"tokenTypes":     tokenTypes,
+						"tokenModifiers": []string{},
+					},
+					"full": true,
+				},
+			},
+			"serverInfo": map[string]string{"name": "re-classify"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+	case "textDocument/didClose":
+		delete(s.documents, params.TextDocument.URI)
+	case "textDocument/semanticTokens/full":
+		text, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, &responseError{Code: codeInvalidParams, Message: "unknown document " + params.TextDocument.URI}
+		}
+		data, err := s.semanticTokens(text)
+		if err != nil {
+			return nil, &responseError{Code: codeInternalError, Message: err.Error()}
+		}
+		return map[string]any{"data": data}, nil
+	default:
+		if msg.ID != nil {
+			return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+		}
+	}
+	return nil, nil
+}
+
+// semanticTokens tokenizes and classifies a document, returning the
+// relative-position encoding required by semanticTokens/full.
+func (s *Server) semanticTokens(text string) ([]uint32, error) {
+	tokens := tokenizer.Tokenize(text)
+
+	var words []string
+	for _, t := range tokens {
+		if t.Classifiable() {
+			words = append(words, t.Text)
+		}
+	}
+	if err := s.engine.BuildFormStartEndMappings(words, s.cfg); err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(text)
+	data := make([]uint32, 0, 5*len(tokens))
+	prevLine, prevChar := 0, 0
+	var prev *classifier.Classification
+	for _, t := range tokens {
+		tokenType, ok := kindTokenTypes[t.Kind]
+		if t.Classifiable() {
+			prev = s.engine.ClassifyAfter(prev, t.Text)
+			tokenType, ok = codeTokenTypes[prev.Code]
+		}
+		if !ok {
+			continue
+		}
+		char := utf16Len(lines[t.Line][:t.Column])
+		deltaChar := char
+		if t.Line == prevLine {
+			deltaChar = char - prevChar
+		}
+		data = append(data, uint32(t.Line-prevLine), uint32(deltaChar), uint32(utf16Len(t.Text)), uint32(tokenType), 0)
+		prevLine, prevChar = t.Line, char
+	}
+	return data, nil
+}
+
+// splitLines splits text at newlines, keeping line numbering congruent with
+// the tokenizer.
+func splitLines(text string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(lines, text[start:])
+}
+
+// utf16Len returns the length of s in UTF-16 code units, which is how LSP
+// measures character offsets.
+func utf16Len(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		n += len(utf16.Encode([]rune{r}))
+		s = s[size:]
+	}
+	return n
+}
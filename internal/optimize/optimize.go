@@ -0,0 +1,219 @@
+// Package optimize reorders the patterns of a config so that the patterns
+// that match most often in a training corpus are listed first, without
+// changing how any token is classified. Each section is matched in a single
+// pass whatever its order, so this makes the order canonical rather than the
+// classification faster.
+package optimize
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/gen"
+)
+
+// probesPerPattern is the number of samples generated from each pattern to
+// check, beyond the corpus, that a new order classifies tokens the same way.
+const probesPerPattern = 20
+
+// Section is the analysis of one section of the config.
+type Section struct {
+	Name     string
+	Patterns []string // The patterns in declared order
+	Hits     []int    // The number of corpus tokens won by each pattern
+	Order    []int    // The proposed order, as indexes into Patterns, or nil
+	Changed  int      // The tokens that the proposed order would match differently
+}
+
+// Reordered reports whether the section should be reordered: the new order
+// lists the patterns more nearly by frequency and changes no match.
+func (s *Section) Reordered() bool {
+	return s.Order != nil && s.Changed == 0
+}
+
+// Plan is the result of analyzing a config against a corpus.
+type Plan struct {
+	Sections []*Section
+	Tokens   int // The size of the corpus
+}
+
+// Analyze classifies the corpus, counts the hits of every pattern and
+// proposes an order for each section that can be reordered. Each proposal is
+// checked by classifying the corpus and samples of every pattern with both
+// orders; if any token is matched by a different pattern the patterns
+// overlap and the proposal is rejected.
+func Analyze(cfg *config.ClassifierConfig, tokens []string, seed uint64) (*Plan, error) {
+	compiled, err := cfg.CompileRegexes()
+	if err != nil {
+		return nil, err
+	}
+	engine := classifier.NewClassifierEngine(compiled)
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		return nil, fmt.Errorf("error building form mappings: %w", err)
+	}
+
+	plan := &Plan{Tokens: len(tokens)}
+	hits := make(map[string][]int)
+	for _, section := range config.PatternSections {
+		if config.Reorderable(section) && len(cfg.SectionPatterns(section)) > 1 {
+			hits[section] = make([]int, len(cfg.SectionPatterns(section)))
+		}
+	}
+	for _, token := range tokens {
+		c := engine.Classify(token)
+		if h, ok := hits[c.Section]; ok {
+			if i := slices.Index(cfg.SectionPatterns(c.Section), c.Pattern); i >= 0 {
+				h[i]++
+			}
+		}
+	}
+
+	probes := append(samples(cfg, seed), tokens...)
+	for _, name := range config.PatternSections {
+		if hits[name] == nil {
+			continue
+		}
+		s := &Section{Name: name, Patterns: cfg.SectionPatterns(name), Hits: hits[name]}
+		plan.Sections = append(plan.Sections, s)
+
+		order := make([]int, len(s.Patterns))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(s.Hits[b], s.Hits[a]) })
+		reordered := cfg.Reordered(name, order)
+		if disorder(reordered, name, s.Hits, order) < disorder(cfg, name, s.Hits, nil) {
+			s.Order = order
+			if s.Changed, err = changed(engine, cfg, reordered, probes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+// disorder measures how far the patterns of a section are from being listed
+// by frequency: the sum over the corpus matches of the position of the
+// matching pattern in the TableOrder of the section. The order maps the
+// declared patterns of cfg onto the hits, nil meaning the identity.
+func disorder(cfg *config.ClassifierConfig, section string, hits []int, order []int) int {
+	total := 0
+	for position, i := range cfg.TableOrder(section) {
+		if order != nil {
+			i = order[i]
+		}
+		total += (position + 1) * hits[i]
+	}
+	return total
+}
+
+// changed counts the tokens that are classified differently, or by a
+// different pattern, by the engine and by an engine for the reordered config.
+// The pattern matters because it can be shown by templates and traces.
+func changed(engine *classifier.ClassifierEngine, cfg, reordered *config.ClassifierConfig, tokens []string) (int, error) {
+	compiled, err := reordered.CompileRegexes()
+	if err != nil {
+		return 0, err
+	}
+	before := engine.Clone()
+	if err := before.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		return 0, fmt.Errorf("error building form mappings: %w", err)
+	}
+	after := classifier.NewClassifierEngine(compiled)
+	if err := after.BuildFormStartEndMappings(tokens, reordered); err != nil {
+		return 0, fmt.Errorf("error building form mappings: %w", err)
+	}
+	n := 0
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		b, a := before.Classify(token), after.Classify(token)
+		if b.String() != a.String() || b.Pattern != a.Pattern {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// samples generates samples of every pattern of the config that the
+// generator can parse; the others, which use the features of the pcre
+// engine, are checked against the corpus alone.
+func samples(cfg *config.ClassifierConfig, seed uint64) []string {
+	g := gen.New(seed)
+	var result []string
+	for _, section := range config.PatternSections {
+		for _, pattern := range cfg.SectionPatterns(section) {
+			s, err := g.Samples(pattern, probesPerPattern)
+			if err != nil {
+				slog.Warn("cannot generate samples", "section", section, "pattern", pattern, "error", err)
+				continue
+			}
+			result = append(result, s...)
+		}
+	}
+	return result
+}
+
+// Rewrite applies the plan to the YAML source of the config, reordering the
+// items of each section that should be. Comments attached to the items move
+// with them.
+func Rewrite(data []byte, plan *Plan) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config is not a mapping")
+	}
+	root := doc.Content[0]
+	for _, s := range plan.Sections {
+		if !s.Reordered() {
+			continue
+		}
+		items := sequence(root, s.Name)
+		if items == nil {
+			// The section was not in the file, e.g. the default numbers.
+			continue
+		}
+		if len(items.Content) != len(s.Patterns) {
+			return nil, fmt.Errorf("%s: expected %d patterns in the file, found %d", s.Name, len(s.Patterns), len(items.Content))
+		}
+		content := make([]*yaml.Node, 0, len(s.Order))
+		for _, i := range s.Order {
+			content = append(content, items.Content[i])
+		}
+		items.Content = content
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// sequence returns the sequence that is the value of key in the mapping, or
+// nil.
+func sequence(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key && mapping.Content[i+1].Kind == yaml.SequenceNode {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
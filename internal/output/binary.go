@@ -0,0 +1,196 @@
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The binary formats, described by proto/classification.proto. Each record
+// is preceded by its length as a varint.
+const (
+	FormatProto   = "proto"
+	FormatMsgpack = "msgpack"
+)
+
+// Formats lists the binary formats.
+var Formats = []string{FormatProto, FormatMsgpack}
+
+// Encoder writes a record in a binary or tabular format.
+type Encoder func(w io.Writer, r Record) error
+
+// NewEncoder returns the encoder for a binary or tabular format. With
+// positions the tables gain the columns of the position of each token; the
+// binary formats always have them, as they omit the empty fields.
+func NewEncoder(format string, positions bool) (Encoder, error) {
+	if comma, ok := tableComma(format); ok {
+		return newTableEncoder(comma, positions), nil
+	}
+	var encode func(b []byte, r Record) []byte
+	switch format {
+	case FormatProto:
+		encode = appendProto
+	case FormatMsgpack:
+		encode = appendMsgpack
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected proto, msgpack, csv or tsv)", format)
+	}
+	var buf []byte
+	return func(w io.Writer, r Record) error {
+		buf = encode(buf[:0], r)
+		_, err := w.Write(frame(buf))
+		return err
+	}, nil
+}
+
+// Protobuf wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// appendProto appends the Classification message of the record, omitting
+// the fields with default values as proto3 does.
+func appendProto(b []byte, r Record) []byte {
+	str := appendProtoString
+	varint := func(b []byte, field int, v uint64) []byte {
+		if v == 0 {
+			return b
+		}
+		b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+		return binary.AppendUvarint(b, v)
+	}
+	if r.Token != "" {
+		b = str(b, 1, r.Token)
+	}
+	if r.Class != "" {
+		b = str(b, 2, r.Class)
+	}
+	for _, s := range r.Data {
+		b = str(b, 3, s)
+	}
+	if r.Section != "" {
+		b = str(b, 4, r.Section)
+	}
+	if r.Pattern != "" {
+		b = str(b, 5, r.Pattern)
+	}
+	// sint32 is zigzag encoded, so that -1 takes a single byte.
+	serial := int32(r.Serial)
+	b = varint(b, 6, uint64(uint32(serial<<1)^uint32(serial>>31)))
+	for _, s := range r.EndTokens {
+		b = str(b, 7, s)
+	}
+	b = varint(b, 8, uint64(r.PrefixPrec))
+	b = varint(b, 9, uint64(r.InfixPrec))
+	b = varint(b, 10, uint64(r.PostfixPrec))
+	if r.File != "" {
+		b = str(b, 11, r.File)
+	}
+	b = varint(b, 12, uint64(r.Line))
+	return varint(b, 13, uint64(r.Col))
+}
+
+// appendProtoString appends a string field.
+func appendProtoString(b []byte, field int, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendProtoBytes appends a bytes field, such as an embedded message.
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendMsgpack appends the record as a MessagePack map with the field names
+// of the Classification message, omitting the fields with default values.
+func appendMsgpack(b []byte, r Record) []byte {
+	type field struct {
+		name  string
+		value any
+	}
+	var fields []field
+	add := func(name string, value any, isDefault bool) {
+		if !isDefault {
+			fields = append(fields, field{name, value})
+		}
+	}
+	add("token", r.Token, r.Token == "")
+	add("code", r.Class, r.Class == "")
+	add("data", r.Data, len(r.Data) == 0)
+	add("section", r.Section, r.Section == "")
+	add("pattern", r.Pattern, r.Pattern == "")
+	add("serial", int64(r.Serial), r.Serial == 0)
+	add("end_tokens", r.EndTokens, len(r.EndTokens) == 0)
+	add("prefix_prec", int64(r.PrefixPrec), r.PrefixPrec == 0)
+	add("infix_prec", int64(r.InfixPrec), r.InfixPrec == 0)
+	add("postfix_prec", int64(r.PostfixPrec), r.PostfixPrec == 0)
+	add("file", r.File, r.File == "")
+	add("line", int64(r.Line), r.Line == 0)
+	add("col", int64(r.Col), r.Col == 0)
+
+	b = appendMsgpackHeader(b, 0x80, 0xde, 0xdf, len(fields))
+	for _, f := range fields {
+		b = appendMsgpackString(b, f.name)
+		switch v := f.value.(type) {
+		case string:
+			b = appendMsgpackString(b, v)
+		case []string:
+			b = appendMsgpackHeader(b, 0x90, 0xdc, 0xdd, len(v))
+			for _, s := range v {
+				b = appendMsgpackString(b, s)
+			}
+		case int64:
+			b = appendMsgpackInt(b, v)
+		}
+	}
+	return b
+}
+
+// appendMsgpackHeader appends the header of a map or array of n items, using
+// the fix, 16-bit or 32-bit form.
+func appendMsgpackHeader(b []byte, fix, code16, code32 byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, code32), uint32(n))
+	}
+}
+
+// appendMsgpackString appends a MessagePack str.
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackInt appends an integer in the smallest MessagePack form. The
+// values written are serial numbers, precedences and positions, which fit in
+// 32 bits.
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= math.MaxInt8:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(int32(v)))
+	}
+}
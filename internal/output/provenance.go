@@ -0,0 +1,63 @@
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Provenance identifies what produced a stream of records, so that archived
+// results can be traced back to the exact config that produced them.
+type Provenance struct {
+	ToolVersion string
+	Config      string // Where the config was read from
+	ConfigHash  string // The fingerprint of the config's settings
+	Timestamp   time.Time
+}
+
+// fields returns the fields of the provenance, named as in the Provenance
+// message of proto/classification.proto.
+func (p Provenance) fields() [][2]string {
+	return [][2]string{
+		{"tool_version", p.ToolVersion},
+		{"config", p.Config},
+		{"config_hash", p.ConfigHash},
+		{"timestamp", p.Timestamp.UTC().Format(time.RFC3339)},
+	}
+}
+
+// ProvenanceHeader returns the provenance in a binary format, to be written
+// ahead of the records: a record with only the provenance field set. The
+// tabular formats have no room for it, since every row is a record.
+func ProvenanceHeader(format string, p Provenance) (string, error) {
+	var b []byte
+	switch format {
+	case FormatProto:
+		var message []byte
+		for i, f := range p.fields() {
+			if f[1] != "" {
+				message = appendProtoString(message, i+1, f[1])
+			}
+		}
+		b = appendProtoBytes(nil, 14, message)
+	case FormatMsgpack:
+		// A map with a single key, provenance, whose value is a map of the
+		// fields.
+		b = appendMsgpackHeader(nil, 0x80, 0xde, 0xdf, 1)
+		b = appendMsgpackString(b, "provenance")
+		fields := p.fields()
+		b = appendMsgpackHeader(b, 0x80, 0xde, 0xdf, len(fields))
+		for _, f := range fields {
+			b = appendMsgpackString(appendMsgpackString(b, f[0]), f[1])
+		}
+	default:
+		return "", fmt.Errorf("unknown format %q (expected proto or msgpack)", format)
+	}
+	return string(frame(b)), nil
+}
+
+// frame prefixes a record with its length as a varint.
+func frame(record []byte) []byte {
+	framed := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(record)), uint64(len(record)))
+	return append(framed, record...)
+}
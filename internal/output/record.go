@@ -0,0 +1,41 @@
+// Package output renders classified tokens in the structured output formats.
+package output
+
+import "github.com/sfkleach/re-classify/internal/classifier"
+
+// Record is a classified token flattened into the fields that the structured
+// output formats draw on.
+type Record struct {
+	Token       string   // The token as read from the input
+	Class       string   // The classification code, as renamed by output-codes
+	Data        []string // The fields following the code in the protocol
+	EndTokens   []string // The possible form-ends of a form-start
+	PrefixPrec  uint16   // Operator precedences, 0 when not an operator
+	InfixPrec   uint16
+	PostfixPrec uint16
+	Serial      int    // The surround group of a form-start or form-end, otherwise -1
+	Section     string // The config section that matched, empty if unclassified
+	Pattern     string // The pattern within that section that matched
+	File        string // The position of the token in its source, when given
+	Line        int    // by structured input, otherwise empty and 0
+	Col         int
+}
+
+// NewRecord flattens the classification of a token.
+func NewRecord(token string, c *classifier.Classification) Record {
+	r := Record{
+		Token:     token,
+		Class:     c.WrittenCode(),
+		Data:      c.Data,
+		EndTokens: c.EndTokens,
+		Serial:    c.Serial,
+		Section:   c.Section,
+		Pattern:   c.Pattern,
+	}
+	if c.Operator != nil {
+		r.PrefixPrec = c.Operator.PrefixPrec
+		r.InfixPrec = c.Operator.InfixPrec
+		r.PostfixPrec = c.Operator.PostfixPrec
+	}
+	return r
+}
@@ -0,0 +1,83 @@
+package output
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The tabular formats, for loading the results into spreadsheets and data
+// tools. Fields are quoted as RFC 4180 describes, with a tab as the separator
+// of TSV.
+const (
+	FormatCSV = "csv"
+	FormatTSV = "tsv"
+)
+
+// TableFormats lists the tabular formats.
+var TableFormats = []string{FormatCSV, FormatTSV}
+
+// tableColumns names the columns of the tabular formats, which positions
+// follow when the input gives them.
+var (
+	tableColumns    = []string{"token", "class", "end_tokens", "prefix_prec", "infix_prec", "postfix_prec"}
+	positionColumns = []string{"file", "line", "col"}
+)
+
+// Header returns the line that names the columns of a tabular format, with
+// or without the positions. The binary formats have no header, so it is
+// empty for them.
+func Header(format string, positions bool) string {
+	comma, ok := tableComma(format)
+	if !ok {
+		return ""
+	}
+	columns := tableColumns
+	if positions {
+		columns = append(columns[:len(columns):len(columns)], positionColumns...)
+	}
+	return strings.Join(columns, string(comma)) + "\n"
+}
+
+// newTableEncoder returns the encoder for a tabular format, which writes a
+// row per record. The end tokens are separated by spaces.
+func newTableEncoder(comma rune, positions bool) Encoder {
+	return func(w io.Writer, r Record) error {
+		row := []string{
+			r.Token,
+			r.Class,
+			strings.Join(r.EndTokens, " "),
+			strconv.Itoa(int(r.PrefixPrec)),
+			strconv.Itoa(int(r.InfixPrec)),
+			strconv.Itoa(int(r.PostfixPrec)),
+		}
+		if positions {
+			row = append(row, r.File, strconv.Itoa(r.Line), strconv.Itoa(r.Col))
+		}
+		return writeRow(w, comma, row)
+	}
+}
+
+// tableComma returns the separator of a tabular format, or false if the
+// format is not tabular.
+func tableComma(format string) (rune, bool) {
+	switch format {
+	case FormatCSV:
+		return ',', true
+	case FormatTSV:
+		return '\t', true
+	}
+	return 0, false
+}
+
+// writeRow writes a row of fields, quoting them where needed.
+func writeRow(w io.Writer, comma rune, fields []string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,69 @@
+package output
+
+import (
+	"encoding/csv"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestTableParses checks that the tabular formats load with encoding/csv,
+// header row first and with the awkward tokens intact.
+func TestTableParses(t *testing.T) {
+	records := []Record{
+		{Token: "if", Class: "S", EndTokens: []string{"fi", "endif"}},
+		{Token: "a,b", Class: "U"},
+		{Token: `"q"`, Class: "Q"},
+		{Token: "a\tb", Class: "U"},
+		{Token: "+", Class: "O", InfixPrec: 50},
+	}
+	for _, format := range TableFormats {
+		for _, positions := range []bool{false, true} {
+			encode, err := NewEncoder(format, positions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sb strings.Builder
+			sb.WriteString(Header(format, positions))
+			for i, r := range records {
+				r.File, r.Line, r.Col = "f.tokens", i+1, 1
+				if err := encode(&sb, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reader := csv.NewReader(strings.NewReader(sb.String()))
+			reader.Comma, _ = tableComma(format)
+			rows, err := reader.ReadAll()
+			if err != nil {
+				t.Fatalf("%s: %v in\n%s", format, err, sb.String())
+			}
+			columns := tableColumns
+			if positions {
+				columns = slices.Concat(tableColumns, positionColumns)
+			}
+			if len(rows) != len(records)+1 || !slices.Equal(rows[0], columns) {
+				t.Fatalf("%s: got rows %q", format, rows)
+			}
+			for i, r := range records {
+				row := rows[i+1]
+				if row[0] != r.Token || row[1] != r.Class || row[2] != strings.Join(r.EndTokens, " ") {
+					t.Errorf("%s: row %d is %q, want token %q class %q", format, i+1, row, r.Token, r.Class)
+				}
+			}
+			if row := rows[5]; row[4] != "50" {
+				t.Errorf("%s: infix_prec is %q, want 50", format, row[4])
+			}
+		}
+	}
+}
+
+// TestProvenanceHeaderTables checks that the tabular formats are refused a
+// provenance header, which would stop them parsing.
+func TestProvenanceHeaderTables(t *testing.T) {
+	for _, format := range TableFormats {
+		if _, err := ProvenanceHeader(format, Provenance{ToolVersion: "1.0"}); err == nil {
+			t.Errorf("%s: got a provenance header", format)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the extra functions available to output templates.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// Template renders each record through a user-supplied text/template.
+type Template struct {
+	tmpl *template.Template
+}
+
+// ParseTemplate parses the text of an output template. The template is
+// executed once per token with a Record as its data, e.g.
+//
+//	{{.Token}} {{.Class}} {{join .EndTokens ","}}
+func ParseTemplate(text string) (*Template, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Write renders a single record followed by a newline.
+func (t *Template) Write(w io.Writer, r Record) error {
+	if err := t.tmpl.Execute(w, r); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
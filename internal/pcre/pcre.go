@@ -0,0 +1,202 @@
+// Package pcre is a regexptable engine for the patterns that RE2 cannot
+// express, such as backreferences and lookarounds. It matches by
+// backtracking, which is slower than RE2 and can take exponential time on
+// some patterns, so each match is given a time limit.
+package pcre
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+	"github.com/sfkleach/regexptable"
+)
+
+// MatchTimeout limits the time taken by a single match. A match that takes
+// longer is abandoned and treated as no match.
+const MatchTimeout = time.Second
+
+// Engine compiles patterns for regexptable with a backtracking matcher. The
+// syntax is that of RE2 with the addition of backreferences such as \1 and
+// \k<name>, lookarounds such as (?=...) and (?<!...), atomic groups and
+// possessive quantifiers.
+type Engine struct{}
+
+// Compile compiles a pattern.
+func (Engine) Compile(pattern string) (regexptable.CompiledRegexp, error) {
+	rewritten, names, keys, err := rewrite(pattern)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp2.Compile(rewritten, regexp2.RE2)
+	if err != nil {
+		return nil, err
+	}
+	re.MatchTimeout = MatchTimeout
+	return &compiled{pattern: pattern, re: re, names: names, keys: keys}, nil
+}
+
+// FormatNamedGroup formats a named capture group.
+func (Engine) FormatNamedGroup(groupName, pattern string) string {
+	return fmt.Sprintf("(?<%s>%s)", groupName, pattern)
+}
+
+// compiled is a compiled pattern. Its capture groups are all named, so that
+// they are numbered in order of appearance as they are by RE2, rather than
+// the unnamed groups first.
+type compiled struct {
+	pattern string
+	re      *regexp2.Regexp
+	names   []string // The names given in the pattern, by position
+	keys    []string // The names that the groups are looked up by
+}
+
+// FindStringSubmatch returns the match and the text of each capture group,
+// or nil if the pattern does not match.
+func (c *compiled) FindStringSubmatch(s string) []string {
+	m, err := c.re.FindStringMatch(s)
+	if err != nil {
+		slog.Warn("abandoned a match that took too long", "pattern", c.pattern, "error", err)
+		return nil
+	}
+	if m == nil {
+		return nil
+	}
+	groups := make([]string, 1, len(c.keys)+1)
+	groups[0] = m.String()
+	for _, key := range c.keys {
+		text := ""
+		if g := m.GroupByName(key); g != nil {
+			text = g.String()
+		}
+		groups = append(groups, text)
+	}
+	return groups
+}
+
+// SubexpNames returns the names of the capture groups, as
+// regexp.Regexp.SubexpNames does.
+func (c *compiled) SubexpNames() []string {
+	return append([]string{""}, c.names...)
+}
+
+// rewrite names the capture groups of a pattern after their position and
+// points the backreferences at those names. A pattern of a table is wrapped
+// in a group named by regexptable; its backreferences refer only to the
+// groups inside it.
+func rewrite(pattern string) (rewritten string, names, keys []string, err error) {
+	var sb strings.Builder
+	var local []string                // The keys of the groups of the current pattern
+	localNames := map[string]string{} // The keys of its named groups
+	inClass, classStart := false, 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && !inClass && (strings.HasPrefix(pattern[i:], `\k<`) || strings.HasPrefix(pattern[i:], `\k'`)):
+			delim := ">"
+			if pattern[i+2] == '\'' {
+				delim = "'"
+			}
+			end := strings.Index(pattern[i+3:], delim)
+			if end < 0 {
+				return "", nil, nil, fmt.Errorf("missing closing %s: %s", delim, pattern[i:])
+			}
+			name := pattern[i+3 : i+3+end]
+			key, ok := localNames[name]
+			if !ok {
+				return "", nil, nil, fmt.Errorf("backreference \\k<%s> refers to a missing capture group", name)
+			}
+			fmt.Fprintf(&sb, `\k<%s>`, key)
+			i += 3 + end
+		case c == '\\' && i+1 < len(pattern):
+			d := pattern[i+1]
+			if inClass || d < '1' || d > '9' {
+				sb.WriteString(pattern[i : i+2])
+				i++
+				continue
+			}
+			j := i + 1
+			for j < len(pattern) && pattern[j] >= '0' && pattern[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(pattern[i+1 : j])
+			if n > len(local) {
+				return "", nil, nil, fmt.Errorf("backreference \\%d refers to a missing capture group", n)
+			}
+			fmt.Fprintf(&sb, `\k<%s>`, local[n-1])
+			i = j - 1
+		case inClass:
+			if c == ']' && i > classStart {
+				inClass = false
+			}
+			sb.WriteByte(c)
+		case c == '[':
+			inClass = true
+			classStart = i + 1
+			if strings.HasPrefix(pattern[i+1:], "^") {
+				classStart++
+			}
+			sb.WriteByte(c)
+		case c == '(' && strings.HasPrefix(pattern[i:], "(?#"):
+			end := strings.IndexByte(pattern[i:], ')')
+			if end < 0 {
+				return "", nil, nil, fmt.Errorf("missing closing ): %s", pattern[i:])
+			}
+			i += end
+		case c == '(' && strings.HasPrefix(pattern[i:], "(?"):
+			name, length := groupName(pattern[i:])
+			if length == 0 {
+				sb.WriteString("(?")
+				i++
+				continue
+			}
+			key := name
+			if strings.HasPrefix(name, "__REGEXPTABLE_") {
+				local, localNames = nil, map[string]string{}
+			} else {
+				// Patterns of the same table may use the same names.
+				key = "_" + strconv.Itoa(len(keys)+1)
+				local = append(local, key)
+				localNames[name] = key
+			}
+			names = append(names, name)
+			keys = append(keys, key)
+			fmt.Fprintf(&sb, "(?<%s>", key)
+			i += length - 1
+		case c == '(':
+			key := "_" + strconv.Itoa(len(keys)+1)
+			local = append(local, key)
+			names = append(names, "")
+			keys = append(keys, key)
+			fmt.Fprintf(&sb, "(?<%s>", key)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), names, keys, nil
+}
+
+// groupName returns the name of the named group that the text starts with
+// and the length of its opening, or a length of 0 if it does not start with
+// one.
+func groupName(text string) (string, int) {
+	var open, delim string
+	switch {
+	case strings.HasPrefix(text, "(?P<"):
+		open, delim = "(?P<", ">"
+	case strings.HasPrefix(text, "(?<") && !strings.HasPrefix(text, "(?<=") && !strings.HasPrefix(text, "(?<!"):
+		open, delim = "(?<", ">"
+	case strings.HasPrefix(text, "(?'"):
+		open, delim = "(?'", "'"
+	default:
+		return "", 0
+	}
+	end := strings.Index(text[len(open):], delim)
+	if end < 0 {
+		return "", 0
+	}
+	return text[len(open) : len(open)+end], len(open) + end + len(delim)
+}
@@ -0,0 +1,208 @@
+// Package protocol implements the framed, versioned protocol that lets a
+// client such as the monogram parser drive re-classify over stdin/stdout and
+// negotiate what it needs, instead of assuming a fixed line format. See
+// docs/classification-protocol.md.
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// Version is the protocol version implemented by Serve.
+const Version = 1
+
+// MaxBatchSize is the largest number of tokens that a batch may have.
+const MaxBatchSize = 1 << 20
+
+// Capabilities that a client may enable.
+const (
+	CapabilityLongNames = "long-names" // Long names in place of the codes
+	CapabilityTokens    = "tokens"     // Each result prefixed with its token and a tab
+	CapabilityEndGroups = "end-groups" // Form-ends followed by the groups they could close
+)
+
+// Capabilities lists the capabilities in the order they are announced.
+var Capabilities = []string{CapabilityLongNames, CapabilityTokens, CapabilityEndGroups}
+
+// Info describes the server in its hello message.
+type Info struct {
+	ToolVersion string // The version of re-classify
+	Config      string // The fingerprint of the config
+}
+
+// Serve announces itself on w and then answers the requests read from r
+// until the client quits or the input ends. Each batch of tokens is
+// classified on its own, with form mappings built from that batch alone.
+// Malformed requests are answered with an error and the session continues;
+// an error is only returned if the session cannot continue.
+func Serve(r io.Reader, w io.Writer, engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, info Info, maxTokenBytes int) error {
+	scanner, maxTokenBytes := newScanner(r, maxTokenBytes)
+	out := bufio.NewWriter(w)
+	opts := &classifier.ProcessOptions{}
+	endGroups := false
+
+	respond := func(format string, args ...any) error {
+		return respondTo(out, format, args...)
+	}
+
+	if err := respond("hello protocol=%d version=%s config=%s capabilities=%s",
+		Version, info.ToolVersion, info.Config, strings.Join(Capabilities, ",")); err != nil {
+		return err
+	}
+
+	for scanner.Scan() {
+		command, args, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		var err error
+		switch command {
+		case "":
+			continue
+		case "quit":
+			return nil
+		case "enable":
+			err = enable(opts, &endGroups, strings.Fields(args), respond)
+		case "batch":
+			err = batch(scanner, out, engine, cfg, opts, endGroups, args, respond, "")
+		default:
+			err = respond("error unknown request %q", command)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanError(scanner, maxTokenBytes)
+}
+
+// newScanner returns a scanner for the lines of the requests, which may be
+// as long as a token, together with the limit on their length.
+func newScanner(r io.Reader, maxTokenBytes int) (*bufio.Scanner, int) {
+	if maxTokenBytes <= 0 {
+		maxTokenBytes = classifier.DefaultMaxTokenBytes
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxTokenBytes)
+	return scanner, maxTokenBytes
+}
+
+// respondTo writes a line of response and flushes it, so that the client
+// sees it at once.
+func respondTo(out *bufio.Writer, format string, args ...any) error {
+	fmt.Fprintf(out, format+"\n", args...)
+	return out.Flush()
+}
+
+// scanError returns the error, if any, that ended the requests.
+func scanError(scanner *bufio.Scanner, maxTokenBytes int) error {
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line longer than the maximum token size of %d bytes", maxTokenBytes)
+		}
+		return err
+	}
+	return nil
+}
+
+// enable turns on the capabilities, all or none of them.
+func enable(opts *classifier.ProcessOptions, endGroups *bool, capabilities []string, respond func(string, ...any) error) error {
+	for _, c := range capabilities {
+		if !slices.Contains(Capabilities, c) {
+			return respond("error unknown capability %q", c)
+		}
+	}
+	for _, c := range capabilities {
+		switch c {
+		case CapabilityLongNames:
+			opts.LongNames = true
+		case CapabilityTokens:
+			opts.ShowTokens = true
+		case CapabilityEndGroups:
+			*endGroups = true
+		}
+	}
+	return respond("ok")
+}
+
+// batch reads the tokens of a batch and writes their classifications, one
+// line per token, after a result line that starts with the prefix.
+func batch(scanner *bufio.Scanner, out *bufio.Writer, engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, opts *classifier.ProcessOptions, endGroups bool, args string, respond func(string, ...any) error, prefix string) error {
+	n, err := batchSize(args)
+	if errors.Is(err, errBatchTooLarge) {
+		// The tokens cannot be skipped, so the session cannot continue.
+		return err
+	}
+	if err != nil {
+		return respond("error %v", err)
+	}
+	policy := engine.TokenPolicy()
+	tokens := make([]string, 0, n)
+	invalid := 0 // The first token that is not valid UTF-8, counting from 1
+	for i := range n {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return endedError(len(tokens), n)
+		}
+		token := strings.TrimRight(scanner.Text(), "\r")
+		if !policy.PreserveWhitespace {
+			token = strings.TrimSpace(token)
+		}
+		if policy.InvalidUTF8 != "" && !utf8.ValidString(token) {
+			if invalid == 0 {
+				invalid = i + 1
+			}
+			token = strings.ToValidUTF8(token, "\uFFFD")
+		}
+		tokens = append(tokens, token)
+	}
+	if invalid != 0 {
+		// The whole batch has been read, so the session can continue.
+		if policy.InvalidUTF8 == config.InvalidUTF8Error {
+			return respond("error token %d of the batch is not valid UTF-8", invalid)
+		}
+		slog.Warn("replaced invalid UTF-8", "token", invalid)
+	}
+
+	engine = engine.Clone()
+	engine.SetReportEndGroups(endGroups)
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		return respond("error building form mappings: %v", err)
+	}
+	fmt.Fprintf(out, "%sresult %d\n", prefix, n)
+	if err := engine.WriteClassifications(out, tokens, opts); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// errBatchTooLarge is wrapped by the error for a batch larger than
+// MaxBatchSize.
+var errBatchTooLarge = fmt.Errorf("exceeds the maximum of %d", MaxBatchSize)
+
+// batchSize parses the size of a batch. A size too large to be read wraps
+// errBatchTooLarge, even if it does not fit in an int.
+func batchSize(args string) (int, error) {
+	n, err := strconv.Atoi(args)
+	if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(args, "-") || err == nil && n > MaxBatchSize {
+		return 0, fmt.Errorf("batch size %s %w", args, errBatchTooLarge)
+	}
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid batch size %q", args)
+	}
+	return n, nil
+}
+
+// endedError reports input that ended part way through a batch.
+func endedError(read, n int) error {
+	return fmt.Errorf("input ended %d tokens into a batch of %d", read, n)
+}
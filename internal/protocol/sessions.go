@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+)
+
+// VersionSessions is the protocol version implemented by ServeSessions.
+const VersionSessions = 2
+
+// DefaultSession is the session that ServeSessions starts with, using the
+// config that it is given.
+const DefaultSession = "default"
+
+// Opener loads and compiles the config that a session is opened with,
+// given the file or URL that the client names.
+type Opener func(ref string) (*classifier.ClassifierEngine, *config.ClassifierConfig, error)
+
+// session is a logical session of ServeSessions, with its own config and
+// capabilities.
+type session struct {
+	engine    *classifier.ClassifierEngine
+	cfg       *config.ClassifierConfig
+	opts      classifier.ProcessOptions
+	endGroups bool
+}
+
+// ServeSessions is like Serve but multiplexes independent sessions over the
+// one stream, so that a single process can serve several clients, each with
+// its own config. Every request but quit starts with the name of its session
+// and every response with the name of the session it answers. DefaultSession
+// is open from the start with the given config; the client opens others
+// with configs that open loads, and may close any of them.
+func ServeSessions(r io.Reader, w io.Writer, engine *classifier.ClassifierEngine, cfg *config.ClassifierConfig, info Info, maxTokenBytes int, open Opener) error {
+	scanner, maxTokenBytes := newScanner(r, maxTokenBytes)
+	out := bufio.NewWriter(w)
+	sessions := map[string]*session{DefaultSession: {engine: engine, cfg: cfg}}
+
+	if err := respondTo(out, "hello protocol=%d version=%s config=%s capabilities=%s",
+		VersionSessions, info.ToolVersion, info.Config, strings.Join(Capabilities, ",")); err != nil {
+		return err
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 && fields[0] == "quit" {
+			return nil
+		}
+		name := fields[0]
+		respond := func(format string, args ...any) error {
+			return respondTo(out, name+" "+format, args...)
+		}
+		if len(fields) == 1 {
+			if err := respond("error missing request"); err != nil {
+				return err
+			}
+			continue
+		}
+		command, args := fields[1], fields[2:]
+		s := sessions[name]
+		var err error
+		switch {
+		case command == "open":
+			err = openSession(sessions, name, args, open, respond)
+		case s == nil:
+			err = unknownSession(scanner, command, args, respond)
+		case command == "close":
+			delete(sessions, name)
+			err = respond("ok")
+		case command == "enable":
+			err = enable(&s.opts, &s.endGroups, args, respond)
+		case command == "batch":
+			err = batch(scanner, out, s.engine, s.cfg, &s.opts, s.endGroups, strings.Join(args, " "), respond, name+" ")
+		default:
+			err = respond("error unknown request %q", command)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanError(scanner, maxTokenBytes)
+}
+
+// openSession opens a session with the config named by the arguments,
+// answering with the fingerprint of the config.
+func openSession(sessions map[string]*session, name string, args []string, open Opener, respond func(string, ...any) error) error {
+	if len(args) != 1 {
+		return respond("error open needs a config")
+	}
+	if sessions[name] != nil {
+		return respond("error session already open")
+	}
+	engine, cfg, err := open(args[0])
+	if err == nil {
+		var fingerprint string
+		if fingerprint, err = cfg.Fingerprint(); err == nil {
+			sessions[name] = &session{engine: engine, cfg: cfg}
+			return respond("ok config=%s", fingerprint)
+		}
+	}
+	// Errors such as those of YAML can span lines.
+	return respond("error %s", strings.Join(strings.Fields(err.Error()), " "))
+}
+
+// unknownSession answers a request for a session that is not open. The
+// tokens of a batch are skipped, so that the requests that follow are read
+// correctly; a batch too large to skip ends the stream.
+func unknownSession(scanner *bufio.Scanner, command string, args []string, respond func(string, ...any) error) error {
+	if command == "batch" {
+		n, err := batchSize(strings.Join(args, " "))
+		if errors.Is(err, errBatchTooLarge) {
+			return err
+		}
+		if err == nil {
+			for i := range n {
+				if !scanner.Scan() {
+					if err := scanner.Err(); err != nil {
+						return err
+					}
+					return endedError(i, n)
+				}
+			}
+		}
+	}
+	return respond("error unknown session")
+}
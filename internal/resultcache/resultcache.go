@@ -0,0 +1,86 @@
+// Package resultcache keeps the output of classification runs on disk, keyed
+// by a hash of everything that determines it, so that running the same
+// config over the same tokens again, as CI often does, can return the
+// stored output at once.
+package resultcache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/sfkleach/re-classify/internal/atomicfile"
+)
+
+// Key accumulates the inputs of a run into a cache key. Each part is length
+// prefixed, so that parts cannot run into one another.
+type Key struct {
+	h hash.Hash
+}
+
+// NewKey returns an empty key.
+func NewKey() *Key {
+	return &Key{h: sha256.New()}
+}
+
+// Add adds a part to the key.
+func (k *Key) Add(part []byte) *Key {
+	k.h.Write(binary.AppendUvarint(nil, uint64(len(part))))
+	k.h.Write(part)
+	return k
+}
+
+// AddString adds a part to the key.
+func (k *Key) AddString(part string) *Key {
+	return k.Add([]byte(part))
+}
+
+// String returns the key in hexadecimal.
+func (k *Key) String() string {
+	return hex.EncodeToString(k.h.Sum(nil))
+}
+
+// Cache is a directory of stored outputs. Entries are never evicted; the
+// directory can be deleted at any time.
+type Cache struct {
+	Dir string
+}
+
+// path returns the file of the entry for a key.
+func (c *Cache) path(key *Key) string {
+	return filepath.Join(c.Dir, key.String()+".out")
+}
+
+// Get returns the stored output for a key, if there is one.
+func (c *Cache) Get(key *Key) ([]byte, bool, error) {
+	data, err := os.ReadFile(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Put stores the output for a key. The entry appears only once it is
+// complete, so concurrent runs never see part of one.
+func (c *Cache) Put(key *Key, output []byte) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := atomicfile.Create(c.path(key))
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if _, err := f.Write(output); err != nil {
+		return err
+	}
+	return f.Commit()
+}
@@ -0,0 +1,164 @@
+// Package server implements the long-running HTTP mode of re-classify.
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sfkleach/re-classify/internal/classifier"
+	"github.com/sfkleach/re-classify/internal/config"
+	"github.com/sfkleach/re-classify/internal/metrics"
+)
+
+// lookupBuckets are the upper bounds, in seconds, of the lookup latency
+// histogram. Most lookups take a few microseconds.
+var lookupBuckets = []float64{1e-6, 2.5e-6, 5e-6, 1e-5, 2.5e-5, 5e-5, 1e-4, 2.5e-4, 1e-3, 1e-2}
+
+// MaxRequestBytes limits the size of a request to classify, so that a single
+// request cannot exhaust memory. It allows for a batch as large as the
+// protocol's, 1048576 tokens, of 64 bytes each.
+const MaxRequestBytes = 64 << 20
+
+// Server classifies token streams posted over HTTP and exposes metrics about
+// its work.
+type Server struct {
+	configFile string
+
+	// A reload swaps the configuration under mu. Each request classifies
+	// with its own clone of the engine, so requests run concurrently.
+	mu     sync.RWMutex
+	cfg    *config.ClassifierConfig
+	engine *classifier.ClassifierEngine
+
+	registry       *metrics.Registry
+	tokensTotal    *metrics.CounterVec
+	patternMatches *metrics.CounterVec
+	reloads        *metrics.CounterVec
+	lookupLatency  *metrics.Histogram
+}
+
+// New creates a server that classifies using the given configuration file.
+func New(configFile string) (*Server, error) {
+	registry := metrics.NewRegistry()
+	s := &Server{
+		configFile: configFile,
+		registry:   registry,
+		tokensTotal: registry.NewCounterVec("reclassify_tokens_classified_total",
+			"Number of tokens classified, by class code.", "class"),
+		patternMatches: registry.NewCounterVec("reclassify_pattern_matches_total",
+			"Number of tokens matched, by config section and pattern.", "section", "pattern"),
+		reloads: registry.NewCounterVec("reclassify_config_reloads_total",
+			"Number of configuration reloads, by result.", "result"),
+		lookupLatency: registry.NewHistogram("reclassify_lookup_duration_seconds",
+			"Time taken to classify a single token.", lookupBuckets),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads and compiles the configuration file, replacing the current
+// configuration only on success.
+func (s *Server) load() error {
+	cfg, err := config.LoadClassifierConfig(s.configFile)
+	if err != nil {
+		return err
+	}
+	compiledConfig, err := cfg.CompileRegexes()
+	if err != nil {
+		return fmt.Errorf("failed to compile regexes: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.engine = classifier.NewClassifierEngine(compiledConfig)
+	return nil
+}
+
+// Reload re-reads the configuration file. On failure the previous
+// configuration stays in effect.
+func (s *Server) Reload() error {
+	if err := s.load(); err != nil {
+		s.reloads.Inc("failure")
+		return err
+	}
+	s.reloads.Inc("success")
+	return nil
+}
+
+// Handler returns the HTTP handler for the server's endpoints:
+//
+//	POST /classify  tokens one per line in, classifications one per line out
+//	GET  /metrics   metrics in the Prometheus text exposition format
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /classify", s.handleClassify)
+	mux.Handle("GET /metrics", s.registry)
+	return mux
+}
+
+func (s *Server) handleClassify(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	policy := s.engine.TokenPolicy()
+	s.mu.RUnlock()
+	body := http.MaxBytesReader(w, r.Body, MaxRequestBytes)
+	tokens, err := classifier.ReadTokensWith(body, 0, policy)
+	if err != nil {
+		status := http.StatusBadRequest
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, fmt.Sprintf("error reading request: %v", err), status)
+		return
+	}
+
+	results, err := s.classify(r.Context(), tokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, result := range results {
+		bw.WriteString(result)
+		bw.WriteByte('\n')
+	}
+	bw.Flush()
+}
+
+// classify classifies a batch of tokens, recording metrics as it goes. It
+// gives up if the client goes away.
+func (s *Server) classify(ctx context.Context, tokens []string) ([]string, error) {
+	s.mu.RLock()
+	cfg, engine := s.cfg, s.engine.Clone()
+	s.mu.RUnlock()
+
+	if err := engine.BuildFormStartEndMappings(tokens, cfg); err != nil {
+		return nil, fmt.Errorf("error building form mappings: %w", err)
+	}
+
+	results := make([]string, 0, len(tokens))
+	start := time.Now()
+	for _, c := range engine.Classifications(ctx, slices.Values(tokens)) {
+		s.lookupLatency.Observe(time.Since(start).Seconds())
+		s.tokensTotal.Inc(c.Code)
+		if c.Section != "" {
+			s.patternMatches.Inc(c.Section, c.Pattern)
+		}
+		results = append(results, c.String())
+		start = time.Now()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMetrics checks that classifying and reloading are counted, and that
+// the counts are served from /metrics.
+func TestMetrics(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	simple, err := os.ReadFile("../../functests/simple-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, simple, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/classify", "text/plain", strings.NewReader("if\nx\n+\nx\nfi\n?\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := "S fi\nV\nO 0 50 0\nV\nE\nU\n"; string(body) != want {
+		t.Errorf("classify: got %q, want %q", body, want)
+	}
+
+	if err := srv.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte("variable-regexp: [\"(\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err == nil {
+		t.Error("reloading a bad config succeeded")
+	}
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, line := range []string{
+		`reclassify_tokens_classified_total{class="V"} 2`,
+		`reclassify_tokens_classified_total{class="U"} 1`,
+		`reclassify_pattern_matches_total{section="surround-regexp",pattern="if"} 1`,
+		`reclassify_pattern_matches_total{section="variable-regexp",pattern="[a-zA-Z_][\\w_]*"} 2`,
+		`reclassify_config_reloads_total{result="success"} 1`,
+		`reclassify_config_reloads_total{result="failure"} 1`,
+		`reclassify_lookup_duration_seconds_bucket{le="+Inf"} 6`,
+		`reclassify_lookup_duration_seconds_count 6`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, body)
+		}
+	}
+
+	// The bad config was not swapped in.
+	resp, err = http.Post(ts.URL+"/classify", "text/plain", strings.NewReader("x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "V\n" {
+		t.Errorf("classify after a failed reload: got %q, want %q", body, "V\n")
+	}
+}
+
+// lines is an endless stream of 64-byte lines, each a token of x's.
+type lines struct{}
+
+func (lines) Read(p []byte) (int, error) {
+	n := len(p) - len(p)%64
+	for i := range n {
+		p[i] = 'x'
+		if i%64 == 63 {
+			p[i] = '\n'
+		}
+	}
+	return n, nil
+}
+
+// TestClassifyTooLarge checks that a request larger than MaxRequestBytes is
+// refused rather than read into memory.
+func TestClassifyTooLarge(t *testing.T) {
+	srv, err := New("../../functests/simple-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/classify", "text/plain", io.LimitReader(lines{}, MaxRequestBytes+64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,115 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind identifies the lexical category of a token.
+type Kind int
+
+const (
+	Word      Kind = iota // Identifiers and keywords, which are classified
+	Sign                  // Runs of punctuation, which are classified
+	Delimiter             // Brackets and separators, which are classified
+	Number                // Numeric literals
+	String                // Quoted string literals
+	Comment               // End-of-line comments
+)
+
+// Token is a lexical token together with its position in the source text.
+type Token struct {
+	Kind   Kind
+	Text   string
+	Line   int // 0-based line number
+	Column int // 0-based byte offset within the line
+}
+
+// Classifiable reports whether the token should be passed to a classifier.
+func (t Token) Classifiable() bool {
+	return t.Kind == Word || t.Kind == Sign || t.Kind == Delimiter
+}
+
+// delimiters are always single-character tokens.
+const delimiters = "()[]{},;"
+
+// Tokenize splits monogram source text into tokens. This is a lightweight
+// lexical analysis that is good enough for driving syntax highlighting: it
+// recognises words, numbers, quoted strings, `#` comments, delimiters and runs
+// of other punctuation.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	line, lineStart := 0, 0
+	i := 0
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		start := i
+		var kind Kind
+		switch {
+		case r == '\n':
+			i += size
+			line++
+			lineStart = i
+			continue
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case r == '#':
+			kind = Comment
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case r == '"' || r == '\'' || r == '`':
+			kind = String
+			i += size
+			for i < len(text) && text[i] != '\n' {
+				if text[i] == '\\' && i+1 < len(text) {
+					i += 2
+					continue
+				}
+				i++
+				if rune(text[i-1]) == r {
+					break
+				}
+			}
+		case unicode.IsDigit(r):
+			kind = Number
+			i = scanWhile(text, i, func(r rune) bool {
+				return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+			})
+		case unicode.IsLetter(r) || r == '_':
+			kind = Word
+			i = scanWhile(text, i, func(r rune) bool {
+				return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+			})
+		case strings.ContainsRune(delimiters, r):
+			kind = Delimiter
+			i += size
+		default:
+			kind = Sign
+			i = scanWhile(text, i, isSign)
+		}
+		tokens = append(tokens, Token{Kind: kind, Text: text[start:i], Line: line, Column: start - lineStart})
+	}
+	return tokens
+}
+
+// isSign reports whether a rune can be part of a run of punctuation.
+func isSign(r rune) bool {
+	if strings.ContainsRune(delimiters+"\"'`#", r) {
+		return false
+	}
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
+
+func scanWhile(text string, i int, pred func(rune) bool) int {
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !pred(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}